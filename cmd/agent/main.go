@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"net/http"
@@ -15,7 +18,9 @@ import (
 	"os/signal"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -23,9 +28,20 @@ import (
 	"github.com/Heidric/metrics.git/internal/crypto"
 	"github.com/Heidric/metrics.git/internal/logger"
 	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/Heidric/metrics.git/internal/outbox"
+	pb "github.com/Heidric/metrics.git/internal/proto"
+	"github.com/Heidric/metrics.git/internal/tlsutil"
 	"github.com/rs/zerolog"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
 )
 
 type Metric struct {
@@ -35,21 +51,54 @@ type Metric struct {
 }
 
 type MetricJob struct {
-	Metric *model.Metrics
-	Ctx    context.Context
+	Metrics []*model.Metrics
+	Ctx     context.Context
+	Done    func()
 }
 
+var (
+	errBatchUnsupported  = errors.New("server does not support batch updates")
+	errServerUnavailable = errors.New("server unavailable")
+)
+
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status: %d %s", e.code, http.StatusText(e.code))
+}
+
+func isRetriable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= http.StatusInternalServerError || se.code == http.StatusTooManyRequests
+	}
+	return errors.Is(err, errServerUnavailable)
+}
+
+const reportTimeout = 30 * time.Second
+
 type Agent struct {
 	serverURL      string
 	pollInterval   time.Duration
 	reportInterval time.Duration
 	hashKey        string
 	rateLimit      int
+	batchSize      int
 	client         *http.Client
 
+	batchUnsupported atomic.Bool
+	outbox           *outbox.Outbox
+	grpcClient       pb.MetricsClient
+	publicKey        *rsa.PublicKey
+	realIP           string
+
 	jobChan    chan MetricJob
 	resultChan chan error
 
+	labels model.Labels
+
 	runtimeMetrics []Metric
 	systemMetrics  []Metric
 	pollCountDelta int64
@@ -57,29 +106,65 @@ type Agent struct {
 	mu       sync.RWMutex
 	stopChan chan struct{}
 	wg       sync.WaitGroup
+	workers  sync.WaitGroup
+	cancel   context.CancelFunc
 }
 
-func parseFlags() (string, time.Duration, time.Duration, string, int) {
-	config, err := cfg.NewConfig()
+type Config struct {
+	cfg.Config
+	RateLimit int
+	BatchSize int
+}
+
+const (
+	transportHTTP = "http"
+	transportGRPC = "grpc"
+)
+
+func parseFlags() *Config {
+	baseCfg, err := cfg.NewConfig()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
 		os.Exit(1)
 	}
 
+	config := &Config{Config: *baseCfg}
+
 	serverAddr := flag.String("a", config.ServerAddress, "HTTP server endpoint address")
 	pollInterval := flag.Int("p", int(config.PollInterval.Seconds()), "Poll interval in seconds")
 	reportInterval := flag.Int("r", int(config.ReportInterval.Seconds()), "Report interval in seconds")
 	databaseDSN := flag.String("d", config.DatabaseDSN, "Database DSN")
 	hashKey := flag.String("k", config.HashKey, "Hash key")
 	rateLimit := flag.Int("l", getEnvInt("RATE_LIMIT", 10), "Rate limit for concurrent requests")
+	batchSize := flag.Int("b", getEnvInt("BATCH_SIZE", 0), "Max metrics per batch request, 0 sends the whole report at once")
+	transport := flag.String("transport", config.Transport, "Transport used to report metrics: http or grpc")
+	grpcAddr := flag.String("g", config.GRPCAddress, "gRPC server endpoint address")
+	tlsCert := flag.String("tls-cert", config.TLSCert, "Client certificate file for mTLS")
+	tlsKey := flag.String("tls-key", config.TLSKey, "Client private key file for mTLS")
+	tlsCA := flag.String("tls-ca", config.TLSCA, "CA bundle used to verify the server certificate")
+	tlsServerName := flag.String("tls-server-name", config.TLSServerName, "Expected server name in the server certificate")
+	cryptoKey := flag.String("crypto-key", config.CryptoKey, "Path to the server public key used to encrypt payloads")
+	agentID := flag.String("id", config.AgentID, "Agent ID label attached to every metric, defaults to the hostname")
 
 	flag.Parse()
 
-	if *databaseDSN != "" {
-		config.DatabaseDSN = *databaseDSN
-	}
-
-	return *serverAddr, time.Duration(*pollInterval) * time.Second, time.Duration(*reportInterval) * time.Second, *hashKey, *rateLimit
+	config.ServerAddress = *serverAddr
+	config.PollInterval = time.Duration(*pollInterval) * time.Second
+	config.ReportInterval = time.Duration(*reportInterval) * time.Second
+	config.DatabaseDSN = *databaseDSN
+	config.HashKey = *hashKey
+	config.RateLimit = *rateLimit
+	config.BatchSize = *batchSize
+	config.AgentID = *agentID
+	config.Transport = *transport
+	config.CryptoKey = *cryptoKey
+	config.TLSCert = *tlsCert
+	config.TLSKey = *tlsKey
+	config.TLSCA = *tlsCA
+	config.TLSServerName = *tlsServerName
+	config.GRPCAddress = *grpcAddr
+
+	return config
 }
 
 func getEnvInt(key string, defaultValue int) int {
@@ -100,6 +185,13 @@ func withRetryHTTP(client *http.Client, req *http.Request) (*http.Response, erro
 	delays := []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
 	var lastErr error
 	for i, delay := range delays {
+		if i > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
 		resp, err := client.Do(req)
 		if err == nil {
 			return resp, nil
@@ -116,8 +208,12 @@ func withRetryHTTP(client *http.Client, req *http.Request) (*http.Response, erro
 }
 
 func NewAgent(serverURL string, pollInterval, reportInterval time.Duration, hashKey string, rateLimit int) *Agent {
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "http://" + serverURL
+	}
+
 	return &Agent{
-		serverURL:      "http://" + serverURL,
+		serverURL:      serverURL,
 		pollInterval:   pollInterval,
 		reportInterval: reportInterval,
 		hashKey:        hashKey,
@@ -129,9 +225,42 @@ func NewAgent(serverURL string, pollInterval, reportInterval time.Duration, hash
 	}
 }
 
+func (a *Agent) SetLabels(labels model.Labels) {
+	a.labels = labels
+}
+
+func (a *Agent) SetBatchSize(size int) {
+	a.batchSize = size
+}
+
+func (a *Agent) SetOutbox(o *outbox.Outbox) {
+	a.outbox = o
+}
+
+func (a *Agent) SetTLSConfig(cfg *tls.Config) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	a.client.Transport = transport
+	a.serverURL = "https://" + strings.TrimPrefix(a.serverURL, "http://")
+}
+
+func (a *Agent) SetPublicKey(key *rsa.PublicKey) {
+	a.publicKey = key
+}
+
+func (a *Agent) SetRealIP(ip string) {
+	a.realIP = ip
+}
+
+func (a *Agent) SetGRPCClient(client pb.MetricsClient) {
+	a.grpcClient = client
+}
+
+// Run starts polling and reporting in the background. The worker pool lives
+// until Stop.
 func (a *Agent) Run() {
 	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	a.cancel = cancel
 
 	a.startWorkerPool(ctx)
 
@@ -143,27 +272,38 @@ func (a *Agent) Run() {
 	go a.processResults()
 }
 
+// Stop stops polling, lets the workers finish the queued reports and then
+// shuts the pool down.
 func (a *Agent) Stop() {
 	close(a.stopChan)
 	a.wg.Wait()
 	close(a.jobChan)
+	a.workers.Wait()
+	if a.cancel != nil {
+		a.cancel()
+	}
 	close(a.resultChan)
 }
 
 func (a *Agent) startWorkerPool(ctx context.Context) {
+	a.workers.Add(a.rateLimit)
 	for i := 0; i < a.rateLimit; i++ {
 		go a.worker(ctx)
 	}
 }
 
 func (a *Agent) worker(ctx context.Context) {
+	defer a.workers.Done()
 	for {
 		select {
 		case job, ok := <-a.jobChan:
 			if !ok {
 				return
 			}
-			err := a.sendMetric(job.Ctx, job.Metric)
+			err := a.sendJob(job)
+			if job.Done != nil {
+				job.Done()
+			}
 			select {
 			case a.resultChan <- err:
 			case <-ctx.Done():
@@ -198,39 +338,194 @@ func (a *Agent) compressData(data []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+func (a *Agent) sendJob(job MetricJob) error {
+	unsent, err := a.send(job.Ctx, job.Metrics)
+	if len(unsent) == 0 || a.outbox == nil {
+		return err
+	}
+
+	if spoolErr := a.outbox.Append(unsent); spoolErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to spool metrics: %w", spoolErr))
+	}
+	logger.Log.Warn().Msgf("Spooled %d metrics to outbox: %v", len(unsent), err)
+	return nil
+}
+
+// send returns the metrics that failed with a retriable error alongside the error itself.
+func (a *Agent) send(ctx context.Context, metrics []*model.Metrics) ([]*model.Metrics, error) {
+	if a.grpcClient != nil {
+		err := a.sendGRPC(ctx, metrics)
+		if isRetriable(err) {
+			return metrics, err
+		}
+		return nil, err
+	}
+
+	if !a.batchUnsupported.Load() {
+		err := a.sendBatch(ctx, metrics)
+		if !errors.Is(err, errBatchUnsupported) {
+			if isRetriable(err) {
+				return metrics, err
+			}
+			return nil, err
+		}
+		if a.batchUnsupported.CompareAndSwap(false, true) {
+			logger.Log.Warn().Msg("Server does not support batch updates, falling back to per-metric mode")
+		}
+	}
+
+	var errs []error
+	var unsent []*model.Metrics
+	for _, metric := range metrics {
+		if err := a.sendMetric(ctx, metric); err != nil {
+			if isRetriable(err) {
+				unsent = append(unsent, metric)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return unsent, errors.Join(errs...)
+}
+
+func (a *Agent) flushOutbox(ctx context.Context) {
+	for {
+		entry := a.outbox.Peek()
+		if entry == nil {
+			return
+		}
+
+		unsent, err := a.send(ctx, entry.Metrics)
+		switch {
+		case len(unsent) > 0 && len(unsent) == len(entry.Metrics):
+			a.outbox.Release(entry.ID)
+			logger.Log.Warn().Msgf("Outbox replay postponed, %d batches pending: %v", a.outbox.Len(), err)
+			return
+		case len(unsent) > 0:
+			if err := a.outbox.Replace(entry.ID, unsent); err != nil {
+				logger.Log.Error().Msgf("Failed to update outbox entry: %v", err)
+			}
+			return
+		case err != nil:
+			logger.Log.Error().Msgf("Dropping rejected outbox batch: %v", err)
+		}
+
+		if err := a.outbox.Remove(entry.ID); err != nil {
+			logger.Log.Error().Msgf("Failed to remove outbox entry: %v", err)
+			return
+		}
+	}
+}
+
+func (a *Agent) sendGRPC(ctx context.Context, metrics []*model.Metrics) error {
+	req := &pb.UpdateMetricsRequest{Metrics: make([]*pb.Metric, 0, len(metrics))}
+	for _, m := range metrics {
+		req.Metrics = append(req.Metrics, pb.FromModel(m))
+	}
+
+	if a.realIP != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-real-ip", a.realIP)
+	}
+
+	_, err := a.grpcClient.UpdateMetrics(ctx, req, grpc.UseCompressor(grpcgzip.Name))
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal:
+		return fmt.Errorf("%w: %w", errServerUnavailable, err)
+	default:
+		return err
+	}
+}
+
+func hashInterceptor(key string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if msg, ok := req.(protobuf.Message); ok {
+			hash, err := pb.MessageHash(msg, key)
+			if err != nil {
+				return fmt.Errorf("failed to hash request: %w", err)
+			}
+			ctx = metadata.AppendToOutgoingContext(ctx, pb.HashMetadataKey, hash)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func (a *Agent) sendBatch(ctx context.Context, metrics []*model.Metrics) error {
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	status, err := a.post(ctx, "/updates/", data)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		return errBatchUnsupported
+	}
+	if status != http.StatusOK {
+		return &statusError{code: status}
+	}
+
+	return nil
+}
+
 func (a *Agent) sendMetric(ctx context.Context, metric *model.Metrics) error {
 	data, err := json.Marshal(metric)
 	if err != nil {
 		return fmt.Errorf("failed to marshal metric: %w", err)
 	}
 
+	status, err := a.post(ctx, "/update/", data)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return &statusError{code: status}
+	}
+
+	return nil
+}
+
+func (a *Agent) post(ctx context.Context, path string, data []byte) (int, error) {
 	compressed, err := a.compressData(data)
 	if err != nil {
-		return fmt.Errorf("failed to compress data: %w", err)
+		return 0, fmt.Errorf("failed to compress data: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.serverURL+"/update/", bytes.NewReader(compressed))
+	body := compressed
+	if a.publicKey != nil {
+		body, err = crypto.Encrypt(a.publicKey, compressed)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt data: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.serverURL+path, bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return 0, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Content-Encoding", "gzip")
+	if a.publicKey != nil {
+		req.Header.Set(crypto.EncryptionHeader, crypto.EncryptionScheme)
+	}
 	if a.hashKey != "" {
 		hash := crypto.HashSHA256(data, a.hashKey)
 		req.Header.Set("HashSHA256", hash)
 	}
+	if a.realIP != "" {
+		req.Header.Set("X-Real-IP", a.realIP)
+	}
 
 	resp, err := withRetryHTTP(a.client, req)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return 0, fmt.Errorf("request failed: %w: %w", errServerUnavailable, err)
 	}
 	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status: %s", resp.Status)
-	}
-
-	return nil
+	return resp.StatusCode, nil
 }
 
 func (a *Agent) pollRuntimeMetrics() {
@@ -340,25 +635,77 @@ func (a *Agent) reportMetrics() {
 			})
 			a.mu.Unlock()
 
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			metrics := make([]*model.Metrics, 0, len(allMetrics))
 			for _, m := range allMetrics {
-				modelMetric := a.convertToModelMetric(m)
-				if modelMetric != nil {
-					select {
-					case a.jobChan <- MetricJob{Metric: modelMetric, Ctx: ctx}:
-					case <-ctx.Done():
-						logger.Log.Error().Msg("Context timeout while sending metrics")
-						break
-					}
+				if modelMetric := a.convertToModelMetric(m); modelMetric != nil {
+					metrics = append(metrics, modelMetric)
 				}
 			}
-			cancel()
+			a.enqueueReport(metrics)
 		case <-a.stopChan:
 			return
 		}
 	}
 }
 
+func (a *Agent) enqueueReport(metrics []*model.Metrics) {
+	if a.outbox != nil && a.outbox.Len() > 0 {
+		if err := a.outbox.Append(metrics); err != nil {
+			logger.Log.Error().Msgf("Failed to spool metrics: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+		a.flushOutbox(ctx)
+		cancel()
+		if n := a.outbox.Dropped(); n > 0 {
+			logger.Log.Warn().Msgf("Outbox limits exceeded, %d batches dropped", n)
+		}
+		return
+	}
+
+	chunks := chunkMetrics(metrics, a.batchSize)
+	if len(chunks) == 0 {
+		return
+	}
+
+	// The report context is released once the last chunk is done.
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	var remaining atomic.Int32
+	remaining.Store(int32(len(chunks)))
+	done := func() {
+		if remaining.Add(-1) == 0 {
+			cancel()
+		}
+	}
+
+	for i, chunk := range chunks {
+		select {
+		case a.jobChan <- MetricJob{Metrics: chunk, Ctx: ctx, Done: done}:
+		case <-ctx.Done():
+			for range chunks[i:] {
+				done()
+			}
+			logger.Log.Error().Msg("Context timeout while sending metrics")
+			return
+		}
+	}
+}
+
+func chunkMetrics(metrics []*model.Metrics, size int) [][]*model.Metrics {
+	if len(metrics) == 0 {
+		return nil
+	}
+	if size <= 0 || size >= len(metrics) {
+		return [][]*model.Metrics{metrics}
+	}
+
+	chunks := make([][]*model.Metrics, 0, (len(metrics)+size-1)/size)
+	for start := 0; start < len(metrics); start += size {
+		end := min(start+size, len(metrics))
+		chunks = append(chunks, metrics[start:end])
+	}
+	return chunks
+}
+
 func (a *Agent) convertToModelMetric(m Metric) *model.Metrics {
 	switch m.Type {
 	case model.GaugeType:
@@ -367,9 +714,10 @@ func (a *Agent) convertToModelMetric(m Metric) *model.Metrics {
 			return nil
 		}
 		return &model.Metrics{
-			ID:    m.Name,
-			MType: model.GaugeType,
-			Value: &v,
+			ID:     m.Name,
+			MType:  model.GaugeType,
+			Value:  &v,
+			Labels: a.labels,
 		}
 	case model.CounterType:
 		d, err := strconv.ParseInt(m.Value, 10, 64)
@@ -377,21 +725,131 @@ func (a *Agent) convertToModelMetric(m Metric) *model.Metrics {
 			return nil
 		}
 		return &model.Metrics{
-			ID:    m.Name,
-			MType: model.CounterType,
-			Delta: &d,
+			ID:     m.Name,
+			MType:  model.CounterType,
+			Delta:  &d,
+			Labels: a.labels,
 		}
 	}
 	return nil
 }
 
+// defaultLabels returns the host and agent_id labels attached to every
+// metric. The agent ID defaults to the hostname, so series stay stable across
+// agent restarts.
+func defaultLabels(agentID string) model.Labels {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	if agentID == "" {
+		agentID = host
+	}
+	return model.Labels{
+		"host":     host,
+		"agent_id": agentID,
+	}
+}
+
+// outboundIP returns the local address the OS picks to reach addr. Dialing UDP
+// sends no packets, it only resolves the route.
+func outboundIP(addr string) (string, error) {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+3:]
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "80")
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
 func main() {
 	log := zerolog.New(os.Stdout).With().Timestamp().Logger()
 	logger.Log = &log
 
-	serverAddr, pollInterval, reportInterval, hashKey, rateLimit := parseFlags()
+	config := parseFlags()
+
+	agent := NewAgent(config.ServerAddress, config.PollInterval, config.ReportInterval, config.HashKey, config.RateLimit)
+	agent.SetLabels(defaultLabels(config.AgentID))
+	agent.SetBatchSize(config.BatchSize)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var tlsConfig *tls.Config
+	if config.TLSCA != "" || config.TLSCert != "" || config.TLSServerName != "" {
+		var reloader *tlsutil.CertReloader
+		var err error
+		if config.TLSCert != "" {
+			reloader, err = tlsutil.NewCertReloader(config.TLSCert, config.TLSKey)
+			if err != nil {
+				logger.Log.Fatal().Err(err).Msg("Failed to load client certificate")
+			}
+			go reloader.Watch(ctx, tlsutil.DefaultReloadInterval)
+		}
+		tlsConfig, err = tlsutil.ClientConfig(config.TLSCA, reloader, config.TLSServerName)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to configure TLS")
+		}
+		agent.SetTLSConfig(tlsConfig)
+	}
+	if config.CryptoKey != "" {
+		key, err := crypto.LoadPublicKey(config.CryptoKey)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to load public key")
+		}
+		agent.SetPublicKey(key)
+	}
+	if config.OutboxDir != "" {
+		box, err := outbox.New(config.OutboxDir, config.OutboxMaxBytes, config.OutboxMaxAge)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to open outbox")
+		}
+		if n := box.Len(); n > 0 {
+			logger.Log.Info().Msgf("Restored %d pending batches from outbox", n)
+		}
+		agent.SetOutbox(box)
+	}
+	target := config.ServerAddress
+	if config.Transport == transportGRPC {
+		target = config.GRPCAddress
+	}
+	if ip, err := outboundIP(target); err != nil {
+		logger.Log.Warn().Err(err).Msg("Failed to detect outbound address, X-Real-IP will not be set")
+	} else {
+		agent.SetRealIP(ip)
+	}
+	switch config.Transport {
+	case transportHTTP:
+	case transportGRPC:
+		if config.GRPCAddress == "" {
+			logger.Log.Fatal().Msg("gRPC transport requires a server address")
+		}
+		creds := insecure.NewCredentials()
+		if tlsConfig != nil {
+			creds = credentials.NewTLS(tlsConfig)
+		}
+		opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+		if config.HashKey != "" {
+			opts = append(opts, grpc.WithUnaryInterceptor(hashInterceptor(config.HashKey)))
+		}
+		conn, err := grpc.NewClient(config.GRPCAddress, opts...)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Failed to create gRPC client")
+		}
+		defer conn.Close()
+		agent.SetGRPCClient(pb.NewMetricsClient(conn))
+	default:
+		logger.Log.Fatal().Msgf("Unknown transport %q", config.Transport)
+	}
 
-	agent := NewAgent(serverAddr, pollInterval, reportInterval, hashKey, rateLimit)
 	agent.Run()
 
 	stop := make(chan os.Signal, 1)
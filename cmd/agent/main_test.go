@@ -1,12 +1,37 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/Heidric/metrics.git/internal/crypto"
+	"github.com/Heidric/metrics.git/internal/logger"
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/Heidric/metrics.git/internal/outbox"
+	pb "github.com/Heidric/metrics.git/internal/proto"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func TestParseFlags(t *testing.T) {
@@ -109,12 +134,12 @@ func TestParseFlags(t *testing.T) {
 
 			tt.setup()
 			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
-			address, poll, report, hashKey, rateLimit := parseFlags()
-			require.Equal(t, tt.wantAddress, address)
-			require.Equal(t, tt.wantPoll, poll)
-			require.Equal(t, tt.wantReport, report)
-			require.Equal(t, tt.wantHashKey, hashKey)
-			require.Equal(t, tt.wantRateLimit, rateLimit)
+			config := parseFlags()
+			require.Equal(t, tt.wantAddress, config.ServerAddress)
+			require.Equal(t, tt.wantPoll, config.PollInterval)
+			require.Equal(t, tt.wantReport, config.ReportInterval)
+			require.Equal(t, tt.wantHashKey, config.HashKey)
+			require.Equal(t, tt.wantRateLimit, config.RateLimit)
 		})
 	}
 }
@@ -255,3 +280,360 @@ func TestConvertToModelMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestDefaultLabels(t *testing.T) {
+	labels := defaultLabels("agent-1")
+	require.Equal(t, "agent-1", labels["agent_id"])
+	require.NotEmpty(t, labels["host"])
+
+	generated := defaultLabels("")
+	require.Equal(t, generated["host"], generated["agent_id"], "the agent ID defaults to the hostname")
+	require.NoError(t, generated.Validate())
+
+	agent := NewAgent("localhost:8080", 2*time.Second, 10*time.Second, "", 1)
+	agent.SetLabels(labels)
+	metric := agent.convertToModelMetric(Metric{Name: "Alloc", Type: "gauge", Value: "1"})
+	require.NotNil(t, metric)
+	require.Equal(t, labels, metric.Labels)
+}
+
+func TestChunkMetrics(t *testing.T) {
+	metrics := make([]*model.Metrics, 5)
+	for i := range metrics {
+		metrics[i] = &model.Metrics{ID: strconv.Itoa(i), MType: model.GaugeType}
+	}
+
+	require.Nil(t, chunkMetrics(nil, 2))
+	require.Len(t, chunkMetrics(metrics, 0), 1)
+	require.Len(t, chunkMetrics(metrics, 10), 1)
+
+	chunks := chunkMetrics(metrics, 2)
+	require.Len(t, chunks, 3)
+	require.Len(t, chunks[2], 1)
+	require.Equal(t, "4", chunks[2][0].ID)
+}
+
+func decodeGzipBody(t *testing.T, r *http.Request, v any) []byte {
+	gz, err := gzip.NewReader(r.Body)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, v))
+	return data
+}
+
+func TestAgentBatchReport(t *testing.T) {
+	l := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &l
+
+	gauge := 1.5
+	delta := int64(3)
+	metrics := []*model.Metrics{
+		{ID: "Alloc", MType: model.GaugeType, Value: &gauge},
+		{ID: "Frees", MType: model.GaugeType, Value: &gauge},
+		{ID: "PollCount", MType: model.CounterType, Delta: &delta},
+	}
+
+	t.Run("Sends signed gzipped batches", func(t *testing.T) {
+		var mu sync.Mutex
+		var batches [][]*model.Metrics
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/updates/", r.URL.Path)
+			require.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+
+			var batch []*model.Metrics
+			data := decodeGzipBody(t, r, &batch)
+			require.Equal(t, crypto.HashSHA256(data, "key"), r.Header.Get("HashSHA256"))
+
+			mu.Lock()
+			batches = append(batches, batch)
+			mu.Unlock()
+		}))
+		defer srv.Close()
+
+		agent := NewAgent(strings.TrimPrefix(srv.URL, "http://"), time.Second, time.Second, "key", 2)
+		agent.SetBatchSize(2)
+		for _, chunk := range chunkMetrics(metrics, agent.batchSize) {
+			require.NoError(t, agent.sendJob(MetricJob{Metrics: chunk, Ctx: context.Background()}))
+		}
+
+		require.Len(t, batches, 2)
+		require.Len(t, batches[0], 2)
+		require.Equal(t, "PollCount", batches[1][0].ID)
+	})
+
+	t.Run("Falls back to per-metric updates on 404", func(t *testing.T) {
+		var batchCalls, singleCalls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/updates/":
+				batchCalls.Add(1)
+				http.NotFound(w, r)
+			case "/update/":
+				var metric model.Metrics
+				decodeGzipBody(t, r, &metric)
+				singleCalls.Add(1)
+			}
+		}))
+		defer srv.Close()
+
+		agent := NewAgent(strings.TrimPrefix(srv.URL, "http://"), time.Second, time.Second, "", 1)
+		job := MetricJob{Metrics: metrics, Ctx: context.Background()}
+		require.NoError(t, agent.sendJob(job))
+		require.NoError(t, agent.sendJob(job))
+
+		require.Equal(t, int32(1), batchCalls.Load(), "batch endpoint must not be retried once unsupported")
+		require.Equal(t, int32(6), singleCalls.Load())
+	})
+
+	t.Run("Batch errors are reported", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer srv.Close()
+
+		agent := NewAgent(strings.TrimPrefix(srv.URL, "http://"), time.Second, time.Second, "", 1)
+		err := agent.sendJob(MetricJob{Metrics: metrics, Ctx: context.Background()})
+		require.Error(t, err)
+		require.False(t, agent.batchUnsupported.Load())
+	})
+}
+
+func TestAgentEnqueueReport(t *testing.T) {
+	agent := NewAgent("localhost:8080", time.Second, time.Second, "", 1)
+	agent.SetBatchSize(1)
+
+	gauge := 1.0
+	agent.enqueueReport([]*model.Metrics{
+		{ID: "a", MType: model.GaugeType, Value: &gauge},
+		{ID: "b", MType: model.GaugeType, Value: &gauge},
+	})
+
+	first := <-agent.jobChan
+	second := <-agent.jobChan
+	require.Equal(t, "a", first.Metrics[0].ID)
+	require.Equal(t, "b", second.Metrics[0].ID)
+
+	first.Done()
+	require.NoError(t, second.Ctx.Err(), "context must stay alive until every chunk is sent")
+	second.Done()
+	require.Eventually(t, func() bool { return second.Ctx.Err() != nil }, time.Second, 10*time.Millisecond)
+}
+
+func TestAgentRun(t *testing.T) {
+	l := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &l
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []*model.Metrics
+		decodeGzipBody(t, r, &batch)
+		requests.Add(1)
+	}))
+	defer srv.Close()
+
+	agent := NewAgent(strings.TrimPrefix(srv.URL, "http://"), 10*time.Millisecond, 20*time.Millisecond, "", 2)
+	agent.Run()
+	require.Eventually(t, func() bool { return requests.Load() >= 2 }, 2*time.Second, 10*time.Millisecond,
+		"the worker pool must keep sending after Run returns")
+	agent.Stop()
+}
+
+func TestAgentOutbox(t *testing.T) {
+	l := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &l
+
+	var available atomic.Bool
+	var mu sync.Mutex
+	var received [][]*model.Metrics
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []*model.Metrics
+		decodeGzipBody(t, r, &batch)
+		mu.Lock()
+		received = append(received, batch)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	box, err := outbox.New(dir, 0, 0)
+	require.NoError(t, err)
+
+	agent := NewAgent(strings.TrimPrefix(srv.URL, "http://"), time.Second, time.Second, "", 1)
+	agent.SetOutbox(box)
+
+	delta := int64(2)
+	first := []*model.Metrics{{ID: "PollCount", MType: model.CounterType, Delta: &delta}}
+	require.NoError(t, agent.sendJob(MetricJob{Metrics: first, Ctx: context.Background()}))
+	require.Equal(t, 1, box.Len(), "failed batch must be spooled")
+
+	restarted := NewAgent(strings.TrimPrefix(srv.URL, "http://"), time.Second, time.Second, "", 1)
+	restored, err := outbox.New(dir, 0, 0)
+	require.NoError(t, err)
+	restarted.SetOutbox(restored)
+
+	second := []*model.Metrics{{ID: "PollCount", MType: model.CounterType, Delta: &delta}}
+	restarted.enqueueReport(second)
+	require.Equal(t, 2, restored.Len(), "report is queued behind pending batches while server is down")
+
+	available.Store(true)
+	restarted.enqueueReport(nil)
+	require.Equal(t, 0, restored.Len())
+	require.Len(t, received, 2)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestIsRetriable(t *testing.T) {
+	require.True(t, isRetriable(&statusError{code: http.StatusBadGateway}))
+	require.True(t, isRetriable(&statusError{code: http.StatusTooManyRequests}))
+	require.False(t, isRetriable(&statusError{code: http.StatusBadRequest}))
+	require.True(t, isRetriable(fmt.Errorf("request failed: %w", errServerUnavailable)))
+	require.False(t, isRetriable(errors.New("marshal failed")))
+	require.False(t, isRetriable(nil))
+}
+
+type fakeMetricsClient struct {
+	pb.MetricsClient
+	err      error
+	requests []*pb.UpdateMetricsRequest
+}
+
+func (f *fakeMetricsClient) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRequest, opts ...grpc.CallOption) (*pb.UpdateMetricsResponse, error) {
+	f.requests = append(f.requests, in)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &pb.UpdateMetricsResponse{}, nil
+}
+
+func TestAgentGRPC(t *testing.T) {
+	l := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &l
+
+	gauge := 2.5
+	metrics := []*model.Metrics{{ID: "Alloc", MType: model.GaugeType, Value: &gauge, Labels: model.Labels{"host": "a"}}}
+
+	t.Run("Sends batch", func(t *testing.T) {
+		client := &fakeMetricsClient{}
+		agent := NewAgent("localhost:8080", time.Second, time.Second, "", 1)
+		agent.SetGRPCClient(client)
+
+		require.NoError(t, agent.sendJob(MetricJob{Metrics: metrics, Ctx: context.Background()}))
+		require.Len(t, client.requests, 1)
+		require.Equal(t, metrics[0], pb.ToModel(client.requests[0].Metrics[0]))
+	})
+
+	t.Run("Unavailable server spools to outbox", func(t *testing.T) {
+		client := &fakeMetricsClient{err: status.Error(codes.Unavailable, "down")}
+		box, err := outbox.New(t.TempDir(), 0, 0)
+		require.NoError(t, err)
+
+		agent := NewAgent("localhost:8080", time.Second, time.Second, "", 1)
+		agent.SetGRPCClient(client)
+		agent.SetOutbox(box)
+
+		require.NoError(t, agent.sendJob(MetricJob{Metrics: metrics, Ctx: context.Background()}))
+		require.Equal(t, 1, box.Len())
+
+		client.err = status.Error(codes.InvalidArgument, "bad")
+		require.Error(t, agent.sendJob(MetricJob{Metrics: metrics, Ctx: context.Background()}))
+		require.Equal(t, 1, box.Len())
+	})
+
+	t.Run("Hash interceptor", func(t *testing.T) {
+		req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{pb.FromModel(metrics[0])}}
+		expected, err := pb.MessageHash(req, "key")
+		require.NoError(t, err)
+
+		var got []string
+		invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			got = md.Get(pb.HashMetadataKey)
+			return nil
+		}
+		require.NoError(t, hashInterceptor("key")(context.Background(), "/metrics.Metrics/UpdateMetrics", req, nil, nil, invoker))
+		require.Equal(t, []string{expected}, got)
+	})
+}
+
+func TestAgentEncryption(t *testing.T) {
+	privatePEM, publicPEM, err := crypto.GenerateKeyPair(2048)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(dir+"/private.pem", privatePEM, 0o600))
+	require.NoError(t, os.WriteFile(dir+"/public.pem", publicPEM, 0o644))
+	priv, err := crypto.LoadPrivateKey(dir + "/private.pem")
+	require.NoError(t, err)
+	pub, err := crypto.LoadPublicKey(dir + "/public.pem")
+	require.NoError(t, err)
+
+	var received []*model.Metrics
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, crypto.EncryptionScheme, r.Header.Get(crypto.EncryptionHeader))
+		sealed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		plain, err := crypto.Decrypt(priv, sealed)
+		require.NoError(t, err)
+
+		gz, err := gzip.NewReader(bytes.NewReader(plain))
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(gz).Decode(&received))
+	}))
+	defer srv.Close()
+
+	agent := NewAgent(strings.TrimPrefix(srv.URL, "http://"), time.Second, time.Second, "", 1)
+	agent.SetPublicKey(pub)
+
+	gauge := 3.0
+	require.NoError(t, agent.sendBatch(context.Background(), []*model.Metrics{{ID: "Alloc", MType: model.GaugeType, Value: &gauge}}))
+	require.Len(t, received, 1)
+	require.Equal(t, "Alloc", received[0].ID)
+}
+
+func TestAgentTLS(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	require.Equal(t, "https://example.com:443", NewAgent("https://example.com:443", time.Second, time.Second, "", 1).serverURL)
+
+	agent := NewAgent(strings.TrimPrefix(srv.URL, "https://"), time.Second, time.Second, "", 1)
+	require.True(t, strings.HasPrefix(agent.serverURL, "http://"))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	agent.SetTLSConfig(&tls.Config{RootCAs: pool})
+	require.True(t, strings.HasPrefix(agent.serverURL, "https://"))
+
+	gauge := 1.0
+	require.NoError(t, agent.sendBatch(context.Background(), []*model.Metrics{{ID: "Alloc", MType: model.GaugeType, Value: &gauge}}))
+	require.Equal(t, int32(1), calls.Load())
+}
+
+func TestAgentRealIP(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Real-IP")
+	}))
+	defer srv.Close()
+
+	ip, err := outboundIP(srv.URL)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", ip)
+
+	agent := NewAgent(srv.URL, time.Second, time.Second, "", 1)
+	agent.SetRealIP(ip)
+
+	gauge := 1.0
+	require.NoError(t, agent.sendBatch(context.Background(), []*model.Metrics{{ID: "Alloc", MType: model.GaugeType, Value: &gauge}}))
+	require.Equal(t, "127.0.0.1", got)
+}
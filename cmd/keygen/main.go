@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Heidric/metrics.git/internal/crypto"
+)
+
+func writeKeys(privatePath, publicPath string, bits int) error {
+	privatePEM, publicPEM, err := crypto.GenerateKeyPair(bits)
+	if err != nil {
+		return fmt.Errorf("failed to generate key pair: %w", err)
+	}
+
+	if err := os.WriteFile(privatePath, privatePEM, 0o600); err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	if err := os.WriteFile(publicPath, publicPEM, 0o644); err != nil {
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+	return nil
+}
+
+func main() {
+	privatePath := flag.String("private", "private.pem", "Output path for the server private key")
+	publicPath := flag.String("public", "public.pem", "Output path for the agent public key")
+	bits := flag.Int("bits", 4096, "RSA key size in bits")
+	flag.Parse()
+
+	if err := writeKeys(*privatePath, *publicPath, *bits); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Private key written to %s\nPublic key written to %s\n", *privatePath, *publicPath)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Heidric/metrics.git/internal/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteKeys(t *testing.T) {
+	dir := t.TempDir()
+	privatePath := filepath.Join(dir, "private.pem")
+	publicPath := filepath.Join(dir, "public.pem")
+
+	require.NoError(t, writeKeys(privatePath, publicPath, 2048))
+
+	info, err := os.Stat(privatePath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	priv, err := crypto.LoadPrivateKey(privatePath)
+	require.NoError(t, err)
+	pub, err := crypto.LoadPublicKey(publicPath)
+	require.NoError(t, err)
+	require.True(t, priv.PublicKey.Equal(pub))
+}
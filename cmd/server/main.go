@@ -2,29 +2,61 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/Heidric/metrics.git/internal/alerting"
+	"github.com/Heidric/metrics.git/internal/audit"
 	"github.com/Heidric/metrics.git/internal/cfg"
+	"github.com/Heidric/metrics.git/internal/crypto"
 	"github.com/Heidric/metrics.git/internal/db"
+	"github.com/Heidric/metrics.git/internal/graphite"
+	"github.com/Heidric/metrics.git/internal/influx"
 	"github.com/Heidric/metrics.git/internal/logger"
 	"github.com/Heidric/metrics.git/internal/server"
+	"github.com/Heidric/metrics.git/internal/server/middleware"
 	"github.com/Heidric/metrics.git/internal/services"
+	"github.com/Heidric/metrics.git/internal/statsd"
+	"github.com/Heidric/metrics.git/internal/tlsutil"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type Config struct {
 	cfg.Config
 	flagAddress         string
+	flagGRPCAddress     string
 	flagFileStoragePath string
+	flagFileStorageGzip bool
 	flagStoreInterval   time.Duration
 	flagRestore         bool
 	flagDatabaseDSN     string
 	flagHashKey         string
+	flagHashGrace       bool
+	flagCryptoKey       string
+	flagTLSCert         string
+	flagTLSKey          string
+	flagTLSCA           string
+	flagTrustedSubnet   string
+	flagRequestTimeout  time.Duration
+	flagAuditFile       string
+	flagAuditURL        string
+	flagStatsDAddress   string
+	flagStatsDFlush     time.Duration
+	flagStatsDTimerMode string
+	flagInfluxIntRules  string
+	flagGraphiteAddress string
+	flagGraphiteConns   int
+	flagAlertRulesPath  string
+	flagAlertInterval   time.Duration
 }
 
 func loadConfig() (*Config, error) {
@@ -36,20 +68,49 @@ func loadConfig() (*Config, error) {
 	config := &Config{Config: *baseCfg}
 
 	flag.StringVar(&config.flagAddress, "a", "", "HTTP server endpoint address")
+	flag.StringVar(&config.flagGRPCAddress, "g", "", "gRPC server endpoint address")
 	flag.StringVar(&config.flagFileStoragePath, "f", "", "file storage path")
+	flag.BoolVar(&config.flagFileStorageGzip, "file-storage-gzip", false, "gzip file storage snapshots")
 	flag.DurationVar(&config.flagStoreInterval, "i", 0, "store interval in seconds")
 	flag.BoolVar(&config.flagRestore, "r", true, "restore data from file")
 	flag.StringVar(&config.flagDatabaseDSN, "d", "", "database DSN")
 	flag.StringVar(&config.flagHashKey, "k", "", "hash key")
+	flag.BoolVar(&config.flagHashGrace, "hash-grace", false, "only log request hash mismatches instead of rejecting")
+	flag.StringVar(&config.flagCryptoKey, "crypto-key", "", "path to the private key used to decrypt agent payloads")
+	flag.StringVar(&config.flagTLSCert, "tls-cert", "", "TLS certificate file, enables HTTPS")
+	flag.StringVar(&config.flagTLSKey, "tls-key", "", "TLS private key file")
+	flag.StringVar(&config.flagTLSCA, "tls-ca", "", "CA bundle used to verify client certificates (mTLS)")
+	flag.StringVar(&config.flagTrustedSubnet, "t", "", "comma-separated trusted subnets in CIDR notation")
+	flag.DurationVar(&config.flagRequestTimeout, "request-timeout", 0, "per-request timeout for service and storage calls, 0 disables it")
+	flag.StringVar(&config.flagAuditFile, "audit-file", "", "file to append audit events to")
+	flag.StringVar(&config.flagAuditURL, "audit-url", "", "URL to POST audit events to")
+	flag.StringVar(&config.flagStatsDAddress, "statsd-address", "", "StatsD UDP/TCP listen address")
+	flag.DurationVar(&config.flagStatsDFlush, "statsd-flush-interval", 0, "StatsD aggregation flush interval")
+	flag.StringVar(&config.flagStatsDTimerMode, "statsd-timer-mode", "", "record StatsD timers as histogram or gauge")
+	flag.StringVar(&config.flagInfluxIntRules, "influx-int-rules", "", "comma-separated pattern=counter|gauge rules for integer line protocol fields; counter fields are cumulative totals")
+	flag.StringVar(&config.flagGraphiteAddress, "graphite-address", "", "Graphite plaintext protocol TCP listen address")
+	flag.IntVar(&config.flagGraphiteConns, "graphite-max-conns", 0, "maximum concurrent Graphite connections")
+	flag.StringVar(&config.flagAlertRulesPath, "alert-rules", "", "alert rules file path")
+	flag.DurationVar(&config.flagAlertInterval, "alert-interval", 0, "alert rules evaluation interval")
 
 	flag.Parse()
 
+	// Zero is a meaningful value for some flags, so check whether they were given.
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
 	if config.flagAddress != "" {
 		config.ServerAddress = config.flagAddress
 	}
+	if config.flagGRPCAddress != "" {
+		config.GRPCAddress = config.flagGRPCAddress
+	}
 	if config.flagFileStoragePath != "" {
 		config.FileStoragePath = config.flagFileStoragePath
 	}
+	if config.flagFileStorageGzip {
+		config.FileStorageGzip = true
+	}
 	if config.flagStoreInterval != 0 {
 		config.StoreInterval = config.flagStoreInterval
 	}
@@ -62,6 +123,57 @@ func loadConfig() (*Config, error) {
 	if config.flagHashKey != "" {
 		config.HashKey = config.flagHashKey
 	}
+	if config.flagHashGrace {
+		config.HashGrace = true
+	}
+	if config.flagCryptoKey != "" {
+		config.CryptoKey = config.flagCryptoKey
+	}
+	if config.flagTLSCert != "" {
+		config.TLSCert = config.flagTLSCert
+	}
+	if config.flagTLSKey != "" {
+		config.TLSKey = config.flagTLSKey
+	}
+	if config.flagTLSCA != "" {
+		config.TLSCA = config.flagTLSCA
+	}
+	if config.flagTrustedSubnet != "" {
+		config.TrustedSubnet = strings.Split(config.flagTrustedSubnet, ",")
+	}
+	if set["request-timeout"] {
+		config.RequestTimeout = config.flagRequestTimeout
+	}
+	if config.flagAuditFile != "" {
+		config.AuditFile = config.flagAuditFile
+	}
+	if config.flagAuditURL != "" {
+		config.AuditURL = config.flagAuditURL
+	}
+	if config.flagStatsDAddress != "" {
+		config.StatsDAddress = config.flagStatsDAddress
+	}
+	if config.flagStatsDFlush != 0 {
+		config.StatsDFlush = config.flagStatsDFlush
+	}
+	if config.flagStatsDTimerMode != "" {
+		config.StatsDTimerMode = config.flagStatsDTimerMode
+	}
+	if config.flagInfluxIntRules != "" {
+		config.InfluxIntRules = strings.Split(config.flagInfluxIntRules, ",")
+	}
+	if config.flagGraphiteAddress != "" {
+		config.GraphiteAddress = config.flagGraphiteAddress
+	}
+	if config.flagGraphiteConns != 0 {
+		config.GraphiteMaxConns = config.flagGraphiteConns
+	}
+	if config.flagAlertRulesPath != "" {
+		config.AlertRulesPath = config.flagAlertRulesPath
+	}
+	if config.flagAlertInterval != 0 {
+		config.AlertInterval = config.flagAlertInterval
+	}
 
 	return config, nil
 }
@@ -70,6 +182,14 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctx, os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	runner, ctx := errgroup.WithContext(ctx)
 
 	config, err := loadConfig()
@@ -86,10 +206,20 @@ func main() {
 	var storage db.MetricsStorage
 
 	if config.DatabaseDSN != "" {
-		storage = db.NewPostgresStore(config.DatabaseDSN)
+		pgStore := db.NewPostgresStore(config.DatabaseDSN)
+		pgStore.SetPoolConfig(db.PoolConfig{
+			MaxOpenConns:    config.DBMaxOpenConns,
+			MaxIdleConns:    config.DBMaxIdleConns,
+			ConnMaxLifetime: config.DBConnLifetime,
+			ConnMaxIdleTime: config.DBConnIdleTime,
+		})
+		pgStore.SetHistoryRetention(config.HistoryRetention)
+		storage = pgStore
 		logger.Zerolog().Info().Msg("Using PostgreSQL storage")
 	} else {
 		fileStore := db.NewStore(config.FileStoragePath, config.StoreInterval)
+		fileStore.SetHistoryRetention(config.HistoryRetention)
+		fileStore.SetCompression(config.FileStorageGzip)
 		if config.Restore {
 			if err := fileStore.LoadFromFile(); err != nil {
 				logger.Zerolog().Error().Err(err).Msg("Failed to load data from file")
@@ -99,10 +229,136 @@ func main() {
 		logger.Zerolog().Info().Msg("Using file storage")
 	}
 
+	var tlsConfig *tls.Config
+	if config.TLSCert != "" {
+		reloader, err := tlsutil.NewCertReloader(config.TLSCert, config.TLSKey)
+		if err != nil {
+			logger.Zerolog().Fatal().Err(err).Msg("Failed to load TLS certificate")
+		}
+		tlsConfig, err = tlsutil.ServerConfig(reloader, config.TLSCA)
+		if err != nil {
+			logger.Zerolog().Fatal().Err(err).Msg("Failed to configure TLS")
+		}
+		runner.Go(func() error {
+			return reloader.Watch(ctx, tlsutil.DefaultReloadInterval)
+		})
+	}
+
+	trustedSubnets, err := middleware.ParseSubnets(config.TrustedSubnet)
+	if err != nil {
+		logger.Zerolog().Fatal().Err(err).Msg("Invalid trusted subnet")
+	}
+
 	metrics := services.NewMetricsService(storage)
+	var auditor *audit.Auditor
+	if config.AuditFile != "" || config.AuditURL != "" {
+		auditor = audit.NewAuditor(logger.Zerolog())
+		var auditFile *audit.FileObserver
+		if config.AuditFile != "" {
+			auditFile, err = audit.NewFileObserver(config.AuditFile)
+			if err != nil {
+				logger.Zerolog().Fatal().Err(err).Msg("Failed to open audit file")
+			}
+			auditor.Attach(auditFile)
+		}
+		if config.AuditURL != "" {
+			auditor.Attach(audit.NewHTTPObserver(config.AuditURL))
+		}
+		runner.Go(func() error {
+			err := auditor.Run(ctx)
+			if auditFile != nil {
+				auditFile.Close()
+			}
+			return err
+		})
+		metrics.SetAuditor(auditor)
+		logger.Zerolog().Info().Msg("Audit enabled")
+	}
+	var grpcServer *server.GRPCServer
+	if config.GRPCAddress != "" {
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcServer = server.NewGRPCServer(config.GRPCAddress, config.HashKey, metrics, opts...)
+		grpcServer.SetHashGrace(config.HashGrace)
+		grpcServer.SetTrustedSubnets(trustedSubnets)
+		grpcServer.SetRequestTimeout(config.RequestTimeout)
+	}
 	server := server.NewServer(config.ServerAddress, config.HashKey, metrics)
+	server.SetHashGrace(config.HashGrace)
+	server.SetTrustedSubnets(trustedSubnets)
+	server.SetRequestTimeout(config.RequestTimeout)
+	if tlsConfig != nil {
+		server.SetTLSConfig(tlsConfig)
+	}
+	influxRules, err := influx.ParseRules(config.InfluxIntRules)
+	if err != nil {
+		logger.Zerolog().Fatal().Err(err).Msg("Invalid influx rules")
+	}
+	server.SetInfluxRules(influxRules)
+	if config.CryptoKey != "" {
+		key, err := crypto.LoadPrivateKey(config.CryptoKey)
+		if err != nil {
+			logger.Zerolog().Fatal().Err(err).Msg("Failed to load private key")
+		}
+		server.SetPrivateKey(key)
+	}
+
+	if config.AlertRulesPath != "" {
+		rules, err := alerting.LoadRules(config.AlertRulesPath)
+		if err != nil {
+			logger.Zerolog().Fatal().Err(err).Msg("Failed to load alert rules")
+		}
+		engine := alerting.NewEngine(storage, rules, config.AlertInterval, logger.Zerolog())
+		server.SetAlerts(engine)
+		if len(config.AlertWebhooks) > 0 {
+			notifier := alerting.NewWebhookNotifier(alerting.WebhookConfig{
+				URLs:           config.AlertWebhooks,
+				HashKey:        config.HashKey,
+				GroupBy:        config.AlertGroupBy,
+				RepeatInterval: config.AlertRepeat,
+			}, logger.Zerolog())
+			engine.SetNotifier(notifier)
+			runner.Go(func() error {
+				return notifier.Run(ctx)
+			})
+		}
+		runner.Go(func() error {
+			return engine.Run(ctx)
+		})
+		logger.Zerolog().Info().Int("rules", len(rules)).Msg("Alerting enabled")
+	}
+
 	server.Run(ctx, runner)
 
+	if grpcServer != nil {
+		if err := grpcServer.Run(ctx, runner); err != nil {
+			logger.Zerolog().Fatal().Err(err).Msg("Failed to start gRPC server")
+		}
+	}
+
+	if config.StatsDAddress != "" {
+		listener := statsd.NewListener(statsd.Config{
+			Address:       config.StatsDAddress,
+			FlushInterval: config.StatsDFlush,
+			TimerMode:     config.StatsDTimerMode,
+		}, metrics, logger.Zerolog())
+		if err := listener.Run(ctx, runner); err != nil {
+			logger.Zerolog().Fatal().Err(err).Msg("Failed to start StatsD listener")
+		}
+	}
+
+	if config.GraphiteAddress != "" {
+		listener := graphite.NewListener(graphite.Config{
+			Address:  config.GraphiteAddress,
+			MaxConns: config.GraphiteMaxConns,
+		}, metrics, logger.Zerolog())
+		if err := listener.Run(ctx, runner); err != nil {
+			logger.Zerolog().Fatal().Err(err).Msg("Failed to start Graphite listener")
+		}
+	}
+
 	if config.DatabaseDSN == "" && config.StoreInterval > 0 {
 		ticker := time.NewTicker(config.StoreInterval)
 		runner.Go(func() error {
@@ -120,18 +376,43 @@ func main() {
 		})
 	}
 
+	if pgStore, ok := storage.(*db.PostgresStore); ok && config.HistoryRetention > 0 {
+		ticker := time.NewTicker(db.HistoryPruneInterval)
+		runner.Go(func() error {
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if _, err := pgStore.PruneHistory(ctx); err != nil {
+						logger.Zerolog().Error().Err(err).Msg("Failed to prune metric history")
+					}
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		})
+	}
+
 	runner.Go(func() error {
 		<-ctx.Done()
-		if config.DatabaseDSN == "" {
-			if err := storage.(*db.Store).SaveToFile(); err != nil {
-				logger.Zerolog().Error().Err(err).Msg("Failed to save data to file on shutdown")
-			}
+		// Let in-flight requests finish before storage is closed below.
+		shutdownCtx := context.WithoutCancel(ctx)
+		if grpcServer != nil {
+			grpcServer.Shutdown(shutdownCtx)
 		}
-		if err := storage.Close(); err != nil {
-			logger.Zerolog().Error().Err(err).Msg("Failed to close storage")
-		}
-		return server.Shutdown(ctx)
+		return server.Shutdown(shutdownCtx)
 	})
 
 	runner.Wait()
+
+	// Every writer, including the final StatsD and Graphite flushes, has
+	// returned by now, so the snapshot below is the last word.
+	if config.DatabaseDSN == "" {
+		if err := storage.(*db.Store).SaveToFile(); err != nil {
+			logger.Zerolog().Error().Err(err).Msg("Failed to save data to file on shutdown")
+		}
+	}
+	if err := storage.Close(); err != nil {
+		logger.Zerolog().Error().Err(err).Msg("Failed to close storage")
+	}
 }
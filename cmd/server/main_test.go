@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -56,3 +59,36 @@ func TestLoadConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadConfigRequestTimeout(t *testing.T) {
+	oldArgs := os.Args
+	oldFlags := flag.CommandLine
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlags
+	}()
+
+	os.Clearenv()
+	os.Args = []string{"cmd"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	config, err := loadConfig()
+	require.NoError(t, err)
+	require.Equal(t, 10*time.Second, config.RequestTimeout)
+
+	os.Args = []string{"cmd", "-request-timeout=0"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	config, err = loadConfig()
+	require.NoError(t, err)
+	require.Zero(t, config.RequestTimeout, "an explicit zero disables the timeout")
+}
+
+func TestRunMigrateArgs(t *testing.T) {
+	ctx := context.Background()
+	os.Clearenv()
+
+	var out bytes.Buffer
+	require.ErrorContains(t, runMigrate(ctx, nil, &out), "usage")
+	require.ErrorContains(t, runMigrate(ctx, []string{"sideways"}, &out), "unknown migrate command")
+	require.ErrorContains(t, runMigrate(ctx, []string{"up"}, &out), "DSN is required")
+	require.ErrorContains(t, runMigrate(ctx, []string{"down", "-steps", "2", "extra"}, &out), "usage")
+}
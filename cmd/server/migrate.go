@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/cfg"
+	"github.com/Heidric/metrics.git/internal/db"
+)
+
+const migrateUsage = "usage: server migrate [-d dsn] up|down [-steps n]|status"
+
+// runMigrate implements the `migrate` subcommand. The DSN falls back to DATABASE_DSN.
+func runMigrate(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	dsn := fs.String("d", "", "database DSN")
+	steps := fs.Int("steps", 1, "number of migrations to revert with down")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New(migrateUsage)
+	}
+	command := fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch command {
+	case "up", "down", "status":
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", command, migrateUsage)
+	}
+
+	if *dsn == "" {
+		config, err := cfg.NewConfig()
+		if err != nil {
+			return err
+		}
+		*dsn = config.DatabaseDSN
+	}
+	if *dsn == "" {
+		return errors.New("database DSN is required: pass -d or set DATABASE_DSN")
+	}
+
+	conn, err := sql.Open("pgx", *dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer conn.Close()
+
+	migrator, err := db.NewMigrator(conn)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		fmt.Fprintf(out, "Applied %d migrations\n", applied)
+		return err
+	case "down":
+		reverted, err := migrator.Down(ctx, *steps)
+		fmt.Fprintf(out, "Reverted %d migrations\n", reverted)
+		return err
+	default:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(out, "%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,305 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/Heidric/metrics.git/internal/db"
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/rs/zerolog"
+)
+
+// DefaultInterval is used when the engine is given a non-positive interval.
+const DefaultInterval = 15 * time.Second
+
+type State string
+
+const (
+	StateInactive State = "inactive"
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+type RuleStatus struct {
+	Rule
+	State       State      `json:"state"`
+	Value       *float64   `json:"value,omitempty"`
+	ActiveSince *time.Time `json:"activeSince,omitempty"`
+	LastEval    *time.Time `json:"lastEval,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+type Alert struct {
+	Name        string            `json:"name"`
+	State       State             `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	Value       float64           `json:"value"`
+	Description string            `json:"description,omitempty"`
+	ActiveSince time.Time         `json:"activeSince"`
+	ResolvedAt  *time.Time        `json:"resolvedAt,omitempty"`
+}
+
+type Notifier interface {
+	Notify(alerts []Alert)
+}
+
+type ruleState struct {
+	rule        Rule
+	state       State
+	value       *float64
+	activeSince time.Time
+	resolvedAt  time.Time
+	lastEval    time.Time
+	lastErr     error
+
+	prevCounter int64
+	prevTime    time.Time
+	hasPrev     bool
+}
+
+type Engine struct {
+	storage  db.MetricsStorage
+	interval time.Duration
+	logger   *zerolog.Logger
+	now      func() time.Time
+	notifier Notifier
+
+	mu    sync.RWMutex
+	rules []*ruleState
+}
+
+func NewEngine(storage db.MetricsStorage, rules []Rule, interval time.Duration, logger *zerolog.Logger) *Engine {
+	if logger == nil {
+		nop := zerolog.Nop()
+		logger = &nop
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	states := make([]*ruleState, 0, len(rules))
+	for _, r := range rules {
+		states = append(states, &ruleState{rule: r, state: StateInactive})
+	}
+
+	return &Engine{
+		storage:  storage,
+		interval: interval,
+		logger:   logger,
+		now:      time.Now,
+		rules:    states,
+	}
+}
+
+func (e *Engine) SetNotifier(notifier Notifier) {
+	e.notifier = notifier
+}
+
+func (e *Engine) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.Evaluate(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (e *Engine) Evaluate(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	for _, rs := range e.rules {
+		e.evaluateRule(ctx, rs, now)
+	}
+
+	if e.notifier != nil {
+		e.notifier.Notify(e.activeAlerts())
+	}
+}
+
+func (e *Engine) activeAlerts() []Alert {
+	var alerts []Alert
+	for _, rs := range e.rules {
+		if rs.state != StateFiring && rs.state != StateResolved {
+			continue
+		}
+
+		labels := make(map[string]string, len(rs.rule.Selector)+len(rs.rule.Labels)+1)
+		for k, v := range rs.rule.Selector {
+			labels[k] = v
+		}
+		for k, v := range rs.rule.Labels {
+			labels[k] = v
+		}
+		labels["alertname"] = rs.rule.Name
+
+		alert := Alert{
+			Name:        rs.rule.Name,
+			State:       rs.state,
+			Labels:      labels,
+			Description: rs.rule.Description,
+			ActiveSince: rs.activeSince,
+		}
+		if rs.value != nil {
+			alert.Value = *rs.value
+		}
+		if rs.state == StateResolved {
+			t := rs.resolvedAt
+			alert.ResolvedAt = &t
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+func (e *Engine) evaluateRule(ctx context.Context, rs *ruleState, now time.Time) {
+	rs.lastEval = now
+
+	value, ok, err := e.sample(ctx, rs, now)
+	if err != nil {
+		rs.lastErr = err
+		e.logger.Error().Err(err).Str("rule", rs.rule.Name).Msg("Failed to evaluate alert rule")
+		return
+	}
+	rs.lastErr = nil
+
+	if ok {
+		rs.value = &value
+	} else {
+		rs.value = nil
+	}
+
+	active := ok && rs.rule.matches(value)
+	next := rs.state
+
+	switch rs.state {
+	case StateInactive, StateResolved:
+		if active {
+			rs.activeSince = now
+			if rs.rule.For.Duration > 0 {
+				next = StatePending
+			} else {
+				next = StateFiring
+			}
+		} else {
+			next = StateInactive
+		}
+	case StatePending:
+		if !active {
+			next = StateInactive
+		} else if now.Sub(rs.activeSince) >= rs.rule.For.Duration {
+			next = StateFiring
+		}
+	case StateFiring:
+		if !active {
+			next = StateResolved
+			rs.resolvedAt = now
+		}
+	}
+
+	if next != rs.state {
+		e.logTransition(rs, next)
+		rs.state = next
+	}
+	if rs.state == StateInactive {
+		rs.activeSince = time.Time{}
+	}
+}
+
+func (e *Engine) sample(ctx context.Context, rs *ruleState, now time.Time) (float64, bool, error) {
+	switch rs.rule.MType {
+	case model.GaugeType:
+		v, err := e.storage.GetGauge(ctx, rs.rule.seriesKey())
+		if errors.Is(err, customerrors.ErrKeyNotFound) {
+			return 0, false, nil
+		}
+		return v, err == nil, err
+	case model.CounterType:
+		v, err := e.storage.GetCounter(ctx, rs.rule.seriesKey())
+		if errors.Is(err, customerrors.ErrKeyNotFound) {
+			rs.hasPrev = false
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		if rs.rule.Func != FuncRate {
+			return float64(v), true, nil
+		}
+		return e.rate(rs, v, now)
+	default:
+		return 0, false, customerrors.ErrInvalidType
+	}
+}
+
+func (e *Engine) rate(rs *ruleState, current int64, now time.Time) (float64, bool, error) {
+	prev, prevTime, hasPrev := rs.prevCounter, rs.prevTime, rs.hasPrev
+	rs.prevCounter, rs.prevTime, rs.hasPrev = current, now, true
+
+	elapsed := now.Sub(prevTime).Seconds()
+	if !hasPrev || elapsed <= 0 {
+		return 0, false, nil
+	}
+
+	delta := current - prev
+	if delta < 0 {
+		delta = current
+	}
+	return float64(delta) / elapsed, true, nil
+}
+
+func (e *Engine) logTransition(rs *ruleState, next State) {
+	event := e.logger.Info()
+	if next == StateFiring {
+		event = e.logger.Warn()
+	}
+	if rs.value != nil {
+		event = event.Float64("value", *rs.value)
+	}
+	event.
+		Str("rule", rs.rule.Name).
+		Str("metric", rs.rule.Metric).
+		Str("from", string(rs.state)).
+		Str("to", string(next)).
+		Msg("Alert state changed")
+}
+
+func (e *Engine) Rules() []RuleStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	result := make([]RuleStatus, 0, len(e.rules))
+	for _, rs := range e.rules {
+		status := RuleStatus{
+			Rule:  rs.rule,
+			State: rs.state,
+		}
+		if rs.value != nil {
+			v := *rs.value
+			status.Value = &v
+		}
+		if !rs.activeSince.IsZero() {
+			t := rs.activeSince
+			status.ActiveSince = &t
+		}
+		if !rs.lastEval.IsZero() {
+			t := rs.lastEval
+			status.LastEval = &t
+		}
+		if rs.lastErr != nil {
+			status.LastError = rs.lastErr.Error()
+		}
+		result = append(result, status)
+	}
+	return result
+}
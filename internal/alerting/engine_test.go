@@ -0,0 +1,197 @@
+package alerting
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/db"
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+func newFakeClock() *fakeClock               { return &fakeClock{t: time.Unix(1700000000, 0)} }
+func newTestEngine(storage db.MetricsStorage, rules ...Rule) (*Engine, *fakeClock) {
+	clock := newFakeClock()
+	e := NewEngine(storage, rules, time.Second, nil)
+	e.now = clock.now
+	return e, clock
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("Valid file", func(t *testing.T) {
+		path := filepath.Join(dir, "rules.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"rules":[
+			{"name":"HighHeap","metric":"HeapAlloc","type":"gauge","op":">","threshold":5e8,"for":"2m"},
+			{"name":"PollRate","metric":"PollCount","type":"counter","func":"rate","op":">","threshold":10,"for":"0s"}
+		]}`), 0o644))
+
+		rules, err := LoadRules(path)
+		require.NoError(t, err)
+		require.Len(t, rules, 2)
+		assert.Equal(t, 2*time.Minute, rules[0].For.Duration)
+		assert.Equal(t, FuncValue, rules[0].Func)
+		assert.Equal(t, FuncRate, rules[1].Func)
+	})
+
+	t.Run("Invalid rules", func(t *testing.T) {
+		cases := map[string]string{
+			"unknown op":       `{"rules":[{"name":"a","metric":"m","type":"gauge","op":"~","for":"1s"}]}`,
+			"rate on gauge":    `{"rules":[{"name":"a","metric":"m","type":"gauge","func":"rate","op":">","for":"1s"}]}`,
+			"bad duration":     `{"rules":[{"name":"a","metric":"m","type":"gauge","op":">","for":"soon"}]}`,
+			"duplicate name":   `{"rules":[{"name":"a","metric":"m","type":"gauge","op":">","for":"1s"},{"name":"a","metric":"n","type":"gauge","op":">","for":"1s"}]}`,
+			"unsupported type": `{"rules":[{"name":"a","metric":"m","type":"text","op":">","for":"1s"}]}`,
+		}
+		for name, content := range cases {
+			path := filepath.Join(dir, "invalid.json")
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+			_, err := LoadRules(path)
+			assert.Error(t, err, name)
+		}
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		_, err := LoadRules(filepath.Join(dir, "missing.json"))
+		assert.Error(t, err)
+	})
+}
+
+func TestEngine_GaugeLifecycle(t *testing.T) {
+	ctx := context.Background()
+	storage := db.NewStore("", 0)
+	defer storage.Close()
+
+	engine, clock := newTestEngine(storage, Rule{
+		Name: "HighHeap", Metric: "HeapAlloc", MType: model.GaugeType, Func: FuncValue,
+		Op: ">", Threshold: 100, For: Duration{2 * time.Minute},
+	})
+
+	state := func() State { return engine.Rules()[0].State }
+
+	engine.Evaluate(ctx)
+	assert.Equal(t, StateInactive, state(), "missing metric must not fire")
+
+	require.NoError(t, storage.SetGauge(ctx, "HeapAlloc", 150))
+	engine.Evaluate(ctx)
+	assert.Equal(t, StatePending, state())
+
+	clock.advance(time.Minute)
+	engine.Evaluate(ctx)
+	assert.Equal(t, StatePending, state())
+
+	clock.advance(time.Minute)
+	engine.Evaluate(ctx)
+	assert.Equal(t, StateFiring, state())
+
+	require.NoError(t, storage.SetGauge(ctx, "HeapAlloc", 50))
+	clock.advance(time.Minute)
+	engine.Evaluate(ctx)
+	assert.Equal(t, StateResolved, state())
+
+	clock.advance(time.Minute)
+	engine.Evaluate(ctx)
+	assert.Equal(t, StateInactive, state())
+
+	status := engine.Rules()[0]
+	require.NotNil(t, status.Value)
+	assert.Equal(t, 50.0, *status.Value)
+	assert.Nil(t, status.ActiveSince)
+}
+
+func TestEngine_PendingResetsWhenConditionClears(t *testing.T) {
+	ctx := context.Background()
+	storage := db.NewStore("", 0)
+	defer storage.Close()
+
+	engine, clock := newTestEngine(storage, Rule{
+		Name: "HighHeap", Metric: "HeapAlloc", MType: model.GaugeType, Func: FuncValue,
+		Op: ">", Threshold: 100, For: Duration{time.Minute},
+	})
+
+	require.NoError(t, storage.SetGauge(ctx, "HeapAlloc", 150))
+	engine.Evaluate(ctx)
+	assert.Equal(t, StatePending, engine.Rules()[0].State)
+
+	require.NoError(t, storage.SetGauge(ctx, "HeapAlloc", 10))
+	clock.advance(2 * time.Minute)
+	engine.Evaluate(ctx)
+	assert.Equal(t, StateInactive, engine.Rules()[0].State)
+}
+
+func TestEngine_LabelSelector(t *testing.T) {
+	ctx := context.Background()
+	storage := db.NewStore("", 0)
+	defer storage.Close()
+
+	require.NoError(t, storage.SetGauge(ctx, "HeapAlloc", 10))
+	require.NoError(t, storage.SetGauge(ctx, model.SeriesKey("HeapAlloc", model.Labels{"host": "web-1"}), 500))
+
+	engine, _ := newTestEngine(storage, Rule{
+		Name: "HighHeap", Metric: "HeapAlloc", MType: model.GaugeType, Func: FuncValue,
+		Selector: model.Labels{"host": "web-1"}, Op: ">", Threshold: 100,
+	})
+
+	engine.Evaluate(ctx)
+	status := engine.Rules()[0]
+	assert.Equal(t, StateFiring, status.State)
+	assert.Equal(t, 500.0, *status.Value)
+	assert.Equal(t, "web-1", engine.activeAlerts()[0].Labels["host"])
+}
+
+func TestEngine_RunWithoutInterval(t *testing.T) {
+	e := NewEngine(db.NewStore("", 0), nil, 0, nil)
+	assert.Equal(t, DefaultInterval, e.interval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, e.Run(ctx))
+}
+
+func TestEngine_CounterRate(t *testing.T) {
+	ctx := context.Background()
+	storage := db.NewStore("", 0)
+	defer storage.Close()
+
+	engine, clock := newTestEngine(storage, Rule{
+		Name: "PollRate", Metric: "PollCount", MType: model.CounterType, Func: FuncRate,
+		Op: ">", Threshold: 5,
+	})
+
+	require.NoError(t, storage.SetCounter(ctx, "PollCount", 100))
+	engine.Evaluate(ctx)
+	assert.Equal(t, StateInactive, engine.Rules()[0].State, "rate needs two samples")
+
+	require.NoError(t, storage.SetCounter(ctx, "PollCount", 100))
+	clock.advance(10 * time.Second)
+	engine.Evaluate(ctx)
+	status := engine.Rules()[0]
+	require.NotNil(t, status.Value)
+	assert.Equal(t, 10.0, *status.Value)
+	assert.Equal(t, StateFiring, status.State)
+
+	require.NoError(t, storage.SetCounter(ctx, "PollCount", 20))
+	clock.advance(10 * time.Second)
+	engine.Evaluate(ctx)
+	status = engine.Rules()[0]
+	assert.Equal(t, 2.0, *status.Value)
+	assert.Equal(t, StateResolved, status.State)
+}
+
+func newGaugeStorage(t *testing.T, name string, value float64) *db.Store {
+	t.Helper()
+	storage := db.NewStore("", 0)
+	t.Cleanup(func() { storage.Close() })
+	require.NoError(t, storage.SetGauge(context.Background(), name, value))
+	return storage
+}
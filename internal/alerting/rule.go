@@ -0,0 +1,129 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/model"
+)
+
+const (
+	FuncValue = "value"
+	FuncRate  = "rate"
+)
+
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = dur
+	return nil
+}
+
+type Rule struct {
+	Name        string            `json:"name"`
+	Metric      string            `json:"metric"`
+	Selector    model.Labels      `json:"selector,omitempty"`
+	MType       string            `json:"type"`
+	Func        string            `json:"func,omitempty"`
+	Op          string            `json:"op"`
+	Threshold   float64           `json:"threshold"`
+	For         Duration          `json:"for"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Description string            `json:"description,omitempty"`
+}
+
+type rulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	var file rulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode rules file: %w", err)
+	}
+
+	seen := make(map[string]struct{}, len(file.Rules))
+	for i := range file.Rules {
+		if err := file.Rules[i].Validate(); err != nil {
+			return nil, fmt.Errorf("rule #%d: %w", i+1, err)
+		}
+		if _, ok := seen[file.Rules[i].Name]; ok {
+			return nil, fmt.Errorf("rule #%d: duplicate name %q", i+1, file.Rules[i].Name)
+		}
+		seen[file.Rules[i].Name] = struct{}{}
+	}
+
+	return file.Rules, nil
+}
+
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if r.Metric == "" {
+		return fmt.Errorf("rule %q: metric is required", r.Name)
+	}
+	if err := r.Selector.Validate(); err != nil {
+		return fmt.Errorf("rule %q: %w", r.Name, err)
+	}
+	if r.MType != model.GaugeType && r.MType != model.CounterType {
+		return fmt.Errorf("rule %q: unsupported metric type %q", r.Name, r.MType)
+	}
+	if r.Func == "" {
+		r.Func = FuncValue
+	}
+	switch r.Func {
+	case FuncValue:
+	case FuncRate:
+		if r.MType != model.CounterType {
+			return fmt.Errorf("rule %q: rate is only supported for counters", r.Name)
+		}
+	default:
+		return fmt.Errorf("rule %q: unsupported func %q", r.Name, r.Func)
+	}
+	if _, ok := comparators[r.Op]; !ok {
+		return fmt.Errorf("rule %q: unsupported operator %q", r.Name, r.Op)
+	}
+	if r.For.Duration < 0 {
+		return fmt.Errorf("rule %q: negative for duration", r.Name)
+	}
+	return nil
+}
+
+var comparators = map[string]func(a, b float64) bool{
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+func (r *Rule) matches(value float64) bool {
+	return comparators[r.Op](value, r.Threshold)
+}
+
+func (r *Rule) seriesKey() string {
+	return model.SeriesKey(r.Metric, r.Selector)
+}
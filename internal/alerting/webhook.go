@@ -0,0 +1,297 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/crypto"
+	"github.com/rs/zerolog"
+)
+
+type WebhookConfig struct {
+	URLs           []string
+	HashKey        string
+	GroupBy        []string
+	RepeatInterval time.Duration
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+}
+
+type WebhookPayload struct {
+	Status      State             `json:"status"`
+	GroupKey    string            `json:"groupKey"`
+	GroupLabels map[string]string `json:"groupLabels"`
+	Alerts      []Alert           `json:"alerts"`
+}
+
+type groupState struct {
+	fingerprint string
+	lastSent    time.Time
+}
+
+type WebhookNotifier struct {
+	cfg    WebhookConfig
+	client *http.Client
+	logger *zerolog.Logger
+	now    func() time.Time
+	wake   chan struct{}
+	// groups tracks the last delivery of every group per URL.
+	groups map[string]map[string]*groupState
+	// undelivered holds resolved alerts that did not reach every URL.
+	undelivered []Alert
+
+	mu      sync.Mutex
+	pending []Alert
+	queued  bool
+}
+
+func NewWebhookNotifier(cfg WebhookConfig, logger *zerolog.Logger) *WebhookNotifier {
+	if logger == nil {
+		nop := zerolog.Nop()
+		logger = &nop
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.RepeatInterval <= 0 {
+		cfg.RepeatInterval = 4 * time.Hour
+	}
+
+	return &WebhookNotifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: logger,
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+		groups: make(map[string]map[string]*groupState),
+	}
+}
+
+// Notify queues the current set of active alerts. Only the latest set is
+// kept while the previous one is still waiting, but its resolved alerts are
+// carried over since they are only reported once.
+func (n *WebhookNotifier) Notify(alerts []Alert) {
+	n.mu.Lock()
+	if n.queued {
+		alerts = carryResolved(alerts, n.pending)
+	}
+	n.pending, n.queued = alerts, true
+	n.mu.Unlock()
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *WebhookNotifier) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-n.wake:
+			n.mu.Lock()
+			alerts := n.pending
+			n.pending, n.queued = nil, false
+			n.mu.Unlock()
+			n.process(ctx, alerts)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (n *WebhookNotifier) process(ctx context.Context, alerts []Alert) {
+	alerts = carryResolved(alerts, n.undelivered)
+	n.undelivered = nil
+
+	groups := n.group(alerts)
+	now := n.now()
+
+	keys := make([]string, 0, len(groups))
+	bodies := make(map[string][]byte, len(groups))
+	for key, payload := range groups {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			n.logger.Error().Err(err).Str("group", key).Msg("Failed to marshal webhook payload")
+			continue
+		}
+		keys = append(keys, key)
+		bodies[key] = body
+	}
+	sort.Strings(keys)
+
+	for _, url := range n.cfg.URLs {
+		sent, ok := n.groups[url]
+		if !ok {
+			sent = make(map[string]*groupState)
+			n.groups[url] = sent
+		}
+		for key := range sent {
+			if _, ok := groups[key]; !ok {
+				delete(sent, key)
+			}
+		}
+
+		for _, key := range keys {
+			payload := groups[key]
+			fingerprint := payloadFingerprint(payload)
+
+			state, ok := sent[key]
+			if ok && state.fingerprint == fingerprint && now.Sub(state.lastSent) < n.cfg.RepeatInterval {
+				continue
+			}
+
+			if err := n.send(ctx, url, bodies[key]); err != nil {
+				n.logger.Error().Err(err).Str("url", url).Str("group", key).Msg("Failed to deliver alert notification")
+				n.keepResolved(payload.Alerts, now)
+				continue
+			}
+			sent[key] = &groupState{fingerprint: fingerprint, lastSent: now}
+		}
+	}
+}
+
+// keepResolved retries resolved alerts on the next run, for as long as a
+// firing alert would be repeated.
+func (n *WebhookNotifier) keepResolved(alerts []Alert, now time.Time) {
+	for _, alert := range alerts {
+		if alert.State == StateResolved && alert.ResolvedAt != nil && now.Sub(*alert.ResolvedAt) < n.cfg.RepeatInterval {
+			n.undelivered = append(n.undelivered, alert)
+		}
+	}
+}
+
+// carryResolved adds the resolved alerts of prev that alerts does not
+// already report on.
+func carryResolved(alerts, prev []Alert) []Alert {
+	if len(prev) == 0 {
+		return alerts
+	}
+	names := make(map[string]bool, len(alerts))
+	for _, alert := range alerts {
+		names[alert.Name] = true
+	}
+	result := append([]Alert(nil), alerts...)
+	for _, alert := range prev {
+		if alert.State == StateResolved && !names[alert.Name] {
+			result = append(result, alert)
+			names[alert.Name] = true
+		}
+	}
+	return result
+}
+
+func (n *WebhookNotifier) group(alerts []Alert) map[string]*WebhookPayload {
+	groups := make(map[string]*WebhookPayload)
+	for _, alert := range alerts {
+		groupLabels := make(map[string]string, len(n.cfg.GroupBy))
+		parts := make([]string, 0, len(n.cfg.GroupBy))
+		for _, name := range n.cfg.GroupBy {
+			value := alert.Labels[name]
+			groupLabels[name] = value
+			parts = append(parts, name+"="+value)
+		}
+		key := "{" + strings.Join(parts, ",") + "}"
+
+		payload, ok := groups[key]
+		if !ok {
+			payload = &WebhookPayload{
+				Status:      StateResolved,
+				GroupKey:    key,
+				GroupLabels: groupLabels,
+			}
+			groups[key] = payload
+		}
+		if alert.State == StateFiring {
+			payload.Status = StateFiring
+		}
+		payload.Alerts = append(payload.Alerts, alert)
+	}
+
+	for _, payload := range groups {
+		sort.Slice(payload.Alerts, func(i, j int) bool {
+			return payload.Alerts[i].Name < payload.Alerts[j].Name
+		})
+	}
+	return groups
+}
+
+func payloadFingerprint(payload *WebhookPayload) string {
+	parts := make([]string, 0, len(payload.Alerts))
+	for _, alert := range payload.Alerts {
+		parts = append(parts, alert.Name+":"+string(alert.State))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (n *WebhookNotifier) send(ctx context.Context, url string, body []byte) error {
+	backoff := n.cfg.InitialBackoff
+	var lastErr error
+
+	for attempt := 0; attempt <= n.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+			if backoff > n.cfg.MaxBackoff {
+				backoff = n.cfg.MaxBackoff
+			}
+		}
+
+		retry, err := n.post(ctx, url, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("webhook delivery failed after retries: %w", lastErr)
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.cfg.HashKey != "" {
+		req.Header.Set("HashSHA256", crypto.HashSHA256(body, n.cfg.HashKey))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status: %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+}
@@ -0,0 +1,272 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	payloads []WebhookPayload
+	hashes   []string
+	bodies   [][]byte
+	statuses []int
+}
+
+func (rc *receiver) handler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+
+		if len(rc.statuses) > 0 {
+			status := rc.statuses[0]
+			rc.statuses = rc.statuses[1:]
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+		}
+
+		var payload WebhookPayload
+		require.NoError(t, json.Unmarshal(body, &payload))
+		rc.payloads = append(rc.payloads, payload)
+		rc.hashes = append(rc.hashes, r.Header.Get("HashSHA256"))
+		rc.bodies = append(rc.bodies, body)
+	}
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.payloads)
+}
+
+func alert(name string, state State, labels map[string]string) Alert {
+	l := map[string]string{"alertname": name}
+	for k, v := range labels {
+		l[k] = v
+	}
+	return Alert{Name: name, State: state, Labels: l}
+}
+
+func TestWebhookNotifier_SignsAndGroups(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc.handler(t))
+	defer srv.Close()
+
+	n := NewWebhookNotifier(WebhookConfig{
+		URLs:    []string{srv.URL},
+		HashKey: "secret",
+		GroupBy: []string{"team"},
+	}, nil)
+
+	n.process(context.Background(), []Alert{
+		alert("A", StateFiring, map[string]string{"team": "core"}),
+		alert("B", StateResolved, map[string]string{"team": "core"}),
+		alert("C", StateResolved, map[string]string{"team": "infra"}),
+	})
+
+	require.Equal(t, 2, rc.count())
+	byGroup := map[string]WebhookPayload{}
+	for i, p := range rc.payloads {
+		byGroup[p.GroupLabels["team"]] = p
+		assert.Equal(t, crypto.HashSHA256(rc.bodies[i], "secret"), rc.hashes[i])
+	}
+	assert.Equal(t, StateFiring, byGroup["core"].Status)
+	assert.Len(t, byGroup["core"].Alerts, 2)
+	assert.Equal(t, StateResolved, byGroup["infra"].Status)
+}
+
+func TestWebhookNotifier_Deduplication(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc.handler(t))
+	defer srv.Close()
+
+	clock := newFakeClock()
+	n := NewWebhookNotifier(WebhookConfig{
+		URLs:           []string{srv.URL},
+		RepeatInterval: time.Hour,
+	}, nil)
+	n.now = clock.now
+
+	firing := []Alert{alert("A", StateFiring, nil)}
+	n.process(context.Background(), firing)
+	n.process(context.Background(), firing)
+	assert.Equal(t, 1, rc.count(), "unchanged group must not be resent")
+
+	clock.advance(time.Hour)
+	n.process(context.Background(), firing)
+	assert.Equal(t, 2, rc.count(), "group must be resent after repeat interval")
+
+	n.process(context.Background(), []Alert{alert("A", StateResolved, nil)})
+	assert.Equal(t, 3, rc.count(), "state change must be sent immediately")
+
+	n.process(context.Background(), nil)
+	n.process(context.Background(), firing)
+	assert.Equal(t, 4, rc.count(), "refired alert must be sent")
+}
+
+func TestWebhookNotifier_Retries(t *testing.T) {
+	t.Run("Retries server errors", func(t *testing.T) {
+		rc := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError}}
+		srv := httptest.NewServer(rc.handler(t))
+		defer srv.Close()
+
+		n := NewWebhookNotifier(WebhookConfig{
+			URLs:           []string{srv.URL},
+			InitialBackoff: time.Millisecond,
+		}, nil)
+
+		n.process(context.Background(), []Alert{alert("A", StateFiring, nil)})
+		assert.Equal(t, 1, rc.count())
+	})
+
+	t.Run("Gives up on client errors", func(t *testing.T) {
+		rc := &receiver{statuses: []int{http.StatusBadRequest}}
+		srv := httptest.NewServer(rc.handler(t))
+		defer srv.Close()
+
+		n := NewWebhookNotifier(WebhookConfig{
+			URLs:           []string{srv.URL},
+			InitialBackoff: time.Millisecond,
+		}, nil)
+
+		err := n.send(context.Background(), srv.URL, []byte(`{}`))
+		assert.Error(t, err)
+		assert.Equal(t, 0, rc.count())
+		assert.Empty(t, rc.statuses)
+	})
+
+	t.Run("Failed delivery is retried on next evaluation", func(t *testing.T) {
+		rc := &receiver{statuses: []int{http.StatusBadRequest}}
+		srv := httptest.NewServer(rc.handler(t))
+		defer srv.Close()
+
+		n := NewWebhookNotifier(WebhookConfig{URLs: []string{srv.URL}}, nil)
+
+		firing := []Alert{alert("A", StateFiring, nil)}
+		n.process(context.Background(), firing)
+		assert.Equal(t, 0, rc.count())
+		n.process(context.Background(), firing)
+		assert.Equal(t, 1, rc.count())
+	})
+}
+
+func TestWebhookNotifier_PerURLDelivery(t *testing.T) {
+	good := &receiver{}
+	goodSrv := httptest.NewServer(good.handler(t))
+	defer goodSrv.Close()
+	flaky := &receiver{statuses: []int{http.StatusBadRequest}}
+	flakySrv := httptest.NewServer(flaky.handler(t))
+	defer flakySrv.Close()
+
+	n := NewWebhookNotifier(WebhookConfig{URLs: []string{goodSrv.URL, flakySrv.URL}}, nil)
+
+	firing := []Alert{alert("A", StateFiring, nil)}
+	n.process(context.Background(), firing)
+	assert.Equal(t, 1, good.count())
+	assert.Equal(t, 0, flaky.count())
+
+	n.process(context.Background(), firing)
+	assert.Equal(t, 1, good.count(), "delivered URL must not be resent")
+	assert.Equal(t, 1, flaky.count(), "failed URL must be retried")
+}
+
+func TestWebhookNotifier_KeepsResolved(t *testing.T) {
+	resolved := func(clock *fakeClock) []Alert {
+		a := alert("A", StateResolved, nil)
+		at := clock.now()
+		a.ResolvedAt = &at
+		return []Alert{a}
+	}
+
+	t.Run("Coalesced notifications", func(t *testing.T) {
+		rc := &receiver{}
+		srv := httptest.NewServer(rc.handler(t))
+		defer srv.Close()
+
+		n := NewWebhookNotifier(WebhookConfig{URLs: []string{srv.URL}}, nil)
+		n.Notify(resolved(newFakeClock()))
+		n.Notify(nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			n.Run(ctx)
+			close(done)
+		}()
+		assert.Eventually(t, func() bool { return rc.count() == 1 }, time.Second, 10*time.Millisecond)
+		cancel()
+		<-done
+		assert.Equal(t, StateResolved, rc.payloads[0].Status)
+	})
+
+	t.Run("Failed delivery", func(t *testing.T) {
+		rc := &receiver{statuses: []int{http.StatusBadRequest}}
+		srv := httptest.NewServer(rc.handler(t))
+		defer srv.Close()
+
+		clock := newFakeClock()
+		n := NewWebhookNotifier(WebhookConfig{URLs: []string{srv.URL}, RepeatInterval: time.Hour}, nil)
+		n.now = clock.now
+
+		n.process(context.Background(), resolved(clock))
+		assert.Equal(t, 0, rc.count())
+		n.process(context.Background(), nil)
+		require.Equal(t, 1, rc.count(), "resolution is retried after the alert left the active set")
+		assert.Equal(t, StateResolved, rc.payloads[0].Status)
+
+		n.process(context.Background(), nil)
+		assert.Equal(t, 1, rc.count())
+	})
+}
+
+type recordingNotifier struct {
+	calls [][]Alert
+}
+
+func (r *recordingNotifier) Notify(alerts []Alert) {
+	r.calls = append(r.calls, alerts)
+}
+
+func TestEngine_NotifiesActiveAlerts(t *testing.T) {
+	ctx := context.Background()
+	storage := newGaugeStorage(t, "HeapAlloc", 150)
+
+	engine, clock := newTestEngine(storage, Rule{
+		Name: "HighHeap", Metric: "HeapAlloc", MType: "gauge", Func: FuncValue,
+		Op: ">", Threshold: 100, Labels: map[string]string{"severity": "page"},
+	})
+	rec := &recordingNotifier{}
+	engine.SetNotifier(rec)
+
+	engine.Evaluate(ctx)
+	require.NoError(t, storage.SetGauge(ctx, "HeapAlloc", 10))
+	clock.advance(time.Minute)
+	engine.Evaluate(ctx)
+	clock.advance(time.Minute)
+	engine.Evaluate(ctx)
+
+	require.Len(t, rec.calls, 3)
+	require.Len(t, rec.calls[0], 1)
+	assert.Equal(t, StateFiring, rec.calls[0][0].State)
+	assert.Equal(t, "HighHeap", rec.calls[0][0].Labels["alertname"])
+	assert.Equal(t, "page", rec.calls[0][0].Labels["severity"])
+	require.Len(t, rec.calls[1], 1)
+	assert.Equal(t, StateResolved, rec.calls[1][0].State)
+	assert.NotNil(t, rec.calls[1][0].ResolvedAt)
+	assert.Empty(t, rec.calls[2])
+}
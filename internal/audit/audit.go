@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+type Event struct {
+	TS        int64    `json:"ts"`
+	Metrics   []string `json:"metrics"`
+	IPAddress string   `json:"ip_address"`
+}
+
+// Observer receives every audit event published by the Auditor.
+type Observer interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+type clientIPKey struct{}
+
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// Auditor fans events out to the attached observers from a background queue so
+// that slow sinks do not hold up metric updates.
+type Auditor struct {
+	mu        sync.RWMutex
+	observers []Observer
+	queue     chan Event
+	logger    *zerolog.Logger
+}
+
+func NewAuditor(logger *zerolog.Logger) *Auditor {
+	if logger == nil {
+		nop := zerolog.Nop()
+		logger = &nop
+	}
+	return &Auditor{
+		queue:  make(chan Event, 1024),
+		logger: logger,
+	}
+}
+
+func (a *Auditor) Attach(observer Observer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.observers = append(a.observers, observer)
+}
+
+func (a *Auditor) Publish(event Event) {
+	select {
+	case a.queue <- event:
+	default:
+		a.logger.Warn().Strs("metrics", event.Metrics).Msg("Audit queue is full, dropping event")
+	}
+}
+
+// Run delivers queued events until ctx is done, then flushes whatever is left.
+// Deliveries are not cancelled by ctx so that shutdown does not lose events.
+func (a *Auditor) Run(ctx context.Context) error {
+	deliverCtx := context.WithoutCancel(ctx)
+	for {
+		select {
+		case event := <-a.queue:
+			a.notify(deliverCtx, event)
+		case <-ctx.Done():
+			a.flush(deliverCtx)
+			return nil
+		}
+	}
+}
+
+func (a *Auditor) flush(ctx context.Context) {
+	for {
+		select {
+		case event := <-a.queue:
+			a.notify(ctx, event)
+		default:
+			return
+		}
+	}
+}
+
+func (a *Auditor) notify(ctx context.Context, event Event) {
+	a.mu.RLock()
+	observers := a.observers
+	a.mu.RUnlock()
+
+	for _, observer := range observers {
+		if err := observer.Notify(ctx, event); err != nil {
+			a.logger.Error().Err(err).Msg("Failed to deliver audit event")
+		}
+	}
+}
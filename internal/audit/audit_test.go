@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingObserver struct{}
+
+func (failingObserver) Notify(ctx context.Context, event Event) error {
+	return errors.New("sink down")
+}
+
+func TestAuditor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	file, err := NewFileObserver(path)
+	require.NoError(t, err)
+
+	var received []Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		received = append(received, event)
+	}))
+	defer srv.Close()
+
+	auditor := NewAuditor(nil)
+	auditor.Attach(failingObserver{})
+	auditor.Attach(file)
+	auditor.Attach(NewHTTPObserver(srv.URL))
+
+	auditor.Publish(Event{TS: 1, Metrics: []string{"Alloc"}, IPAddress: "10.0.0.1"})
+	auditor.Publish(Event{TS: 2, Metrics: []string{"PollCount", "Alloc"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, auditor.Run(ctx))
+	require.NoError(t, file.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var lines []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		lines = append(lines, event)
+	}
+	require.NoError(t, scanner.Err())
+
+	expected := []Event{
+		{TS: 1, Metrics: []string{"Alloc"}, IPAddress: "10.0.0.1"},
+		{TS: 2, Metrics: []string{"PollCount", "Alloc"}},
+	}
+	assert.Equal(t, expected, lines)
+	assert.Equal(t, expected, received)
+}
+
+func TestHTTPObserverStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	err := NewHTTPObserver(srv.URL).Notify(context.Background(), Event{TS: 1})
+	assert.Error(t, err)
+}
+
+func TestClientIP(t *testing.T) {
+	assert.Empty(t, ClientIP(context.Background()))
+	assert.Equal(t, "10.0.0.1", ClientIP(WithClientIP(context.Background(), "10.0.0.1")))
+}
@@ -0,0 +1,44 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileObserver appends events to a file as JSON lines.
+type FileObserver struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileObserver(path string) (*FileObserver, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	return &FileObserver{file: file}, nil
+}
+
+func (o *FileObserver) Notify(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %w", err)
+	}
+	data = append(data, '\n')
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, err := o.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+	return nil
+}
+
+func (o *FileObserver) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.file.Close()
+}
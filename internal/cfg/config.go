@@ -3,6 +3,7 @@ package cfg
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Heidric/metrics.git/pkg/log"
@@ -10,15 +11,48 @@ import (
 )
 
 type Config struct {
-	Logger          *log.Config
-	ServerAddress   string
-	PollInterval    time.Duration
-	ReportInterval  time.Duration
-	StoreInterval   time.Duration
-	FileStoragePath string
-	Restore         bool
-	DatabaseDSN     string
-	HashKey         string
+	Logger           *log.Config
+	ServerAddress    string
+	GRPCAddress      string
+	Transport        string
+	PollInterval     time.Duration
+	ReportInterval   time.Duration
+	StoreInterval    time.Duration
+	FileStoragePath  string
+	FileStorageGzip  bool
+	Restore          bool
+	DatabaseDSN      string
+	DBMaxOpenConns   int
+	DBMaxIdleConns   int
+	DBConnLifetime   time.Duration
+	DBConnIdleTime   time.Duration
+	HashKey          string
+	HashGrace        bool
+	CryptoKey        string
+	TLSCert          string
+	TLSKey           string
+	TLSCA            string
+	TLSServerName    string
+	TrustedSubnet    []string
+	RequestTimeout   time.Duration
+	AuditFile        string
+	AuditURL         string
+	StatsDAddress    string
+	StatsDFlush      time.Duration
+	StatsDTimerMode  string
+	InfluxIntRules   []string
+	GraphiteAddress  string
+	GraphiteMaxConns int
+	AgentID          string
+	OutboxDir        string
+	OutboxMaxBytes   int64
+	OutboxMaxAge     time.Duration
+	HistoryRetention time.Duration
+	AlertRulesPath   string
+	AlertInterval    time.Duration
+	AlertWebhooks    []string
+	AlertGroupBy     []string
+	AlertRepeat      time.Duration
 }
 
 func NewConfig() (*Config, error) {
@@ -29,13 +63,46 @@ func NewConfig() (*Config, error) {
 	}
 
 	config.ServerAddress = getEnv("ADDRESS", "localhost:8080")
+	config.GRPCAddress = getEnv("GRPC_ADDRESS", "")
+	config.Transport = getEnv("TRANSPORT", "http")
 	config.PollInterval = parseDuration("POLL_INTERVAL", 2*time.Second)
 	config.ReportInterval = parseDuration("REPORT_INTERVAL", 10*time.Second)
 	config.StoreInterval = parseDuration("STORE_INTERVAL", 300*time.Second)
 	config.FileStoragePath = getEnv("FILE_STORAGE_PATH", "/tmp/metrics-db.json")
+	config.FileStorageGzip = parseBool("FILE_STORAGE_GZIP", false)
 	config.Restore = parseBool("RESTORE", true)
 	config.DatabaseDSN = getEnv("DATABASE_DSN", "")
+	config.DBMaxOpenConns = int(parseInt64("DB_MAX_OPEN_CONNS", 25))
+	config.DBMaxIdleConns = int(parseInt64("DB_MAX_IDLE_CONNS", 10))
+	config.DBConnLifetime = parseDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute)
+	config.DBConnIdleTime = parseDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute)
 	config.HashKey = getEnv("HASH_KEY", "")
+	config.HashGrace = parseBool("HASH_GRACE", false)
+	config.CryptoKey = getEnv("CRYPTO_KEY", "")
+	config.TLSCert = getEnv("TLS_CERT_FILE", "")
+	config.TLSKey = getEnv("TLS_KEY_FILE", "")
+	config.TLSCA = getEnv("TLS_CA_FILE", "")
+	config.TLSServerName = getEnv("TLS_SERVER_NAME", "")
+	config.TrustedSubnet = parseList("TRUSTED_SUBNET")
+	config.RequestTimeout = parseDuration("REQUEST_TIMEOUT", 10*time.Second)
+	config.AuditFile = getEnv("AUDIT_FILE", "")
+	config.AuditURL = getEnv("AUDIT_URL", "")
+	config.StatsDAddress = getEnv("STATSD_ADDRESS", "")
+	config.StatsDFlush = parseDuration("STATSD_FLUSH_INTERVAL", 10*time.Second)
+	config.StatsDTimerMode = getEnv("STATSD_TIMER_MODE", "histogram")
+	config.InfluxIntRules = parseList("INFLUX_INT_RULES")
+	config.GraphiteAddress = getEnv("GRAPHITE_ADDRESS", "")
+	config.GraphiteMaxConns = int(parseInt64("GRAPHITE_MAX_CONNS", 100))
+	config.AgentID = getEnv("AGENT_ID", "")
+	config.OutboxDir = getEnv("OUTBOX_DIR", "")
+	config.OutboxMaxBytes = parseInt64("OUTBOX_MAX_BYTES", 10<<20)
+	config.OutboxMaxAge = parseDuration("OUTBOX_MAX_AGE", 24*time.Hour)
+	config.HistoryRetention = parseDuration("HISTORY_RETENTION", time.Hour)
+	config.AlertRulesPath = getEnv("ALERT_RULES_PATH", "")
+	config.AlertInterval = parseDuration("ALERT_INTERVAL", 15*time.Second)
+	config.AlertWebhooks = parseList("ALERT_WEBHOOK_URLS")
+	config.AlertGroupBy = parseList("ALERT_GROUP_BY")
+	config.AlertRepeat = parseDuration("ALERT_REPEAT_INTERVAL", 4*time.Hour)
 
 	config.Logger.SetDefault()
 	return config, nil
@@ -60,6 +127,29 @@ func parseDuration(key string, defaultValue time.Duration) time.Duration {
 	return defaultValue
 }
 
+func parseList(key string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func parseInt64(key string, defaultValue int64) int64 {
+	if value, ok := os.LookupEnv(key); ok {
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
 func parseBool(key string, defaultValue bool) bool {
 	if value, ok := os.LookupEnv(key); ok {
 		if b, err := strconv.ParseBool(value); err == nil {
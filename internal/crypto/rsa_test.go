@@ -0,0 +1,58 @@
+package crypto
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestKeys(t *testing.T) (privatePath, publicPath string) {
+	t.Helper()
+	privatePEM, publicPEM, err := GenerateKeyPair(2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	privatePath = filepath.Join(dir, "private.pem")
+	publicPath = filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(privatePath, privatePEM, 0o600))
+	require.NoError(t, os.WriteFile(publicPath, publicPEM, 0o644))
+	return privatePath, publicPath
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	privatePath, publicPath := writeTestKeys(t)
+	priv, err := LoadPrivateKey(privatePath)
+	require.NoError(t, err)
+	pub, err := LoadPublicKey(publicPath)
+	require.NoError(t, err)
+
+	payload := []byte(`[{"id":"Alloc","type":"gauge","value":1}]`)
+	sealed, err := Encrypt(pub, payload)
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "Alloc")
+
+	plain, err := Decrypt(priv, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, payload, plain)
+
+	sealed[len(sealed)-1] ^= 0xff
+	_, err = Decrypt(priv, sealed)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+
+	_, err = Decrypt(priv, []byte("short"))
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+}
+
+func TestLoadKeys(t *testing.T) {
+	privatePath, publicPath := writeTestKeys(t)
+
+	_, err := LoadPublicKey(privatePath)
+	assert.Error(t, err, "private key file is not a public key")
+	_, err = LoadPrivateKey(publicPath)
+	assert.Error(t, err)
+	_, err = LoadPublicKey(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,156 @@
+package cumulative
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// staleAfter bounds how long a series is remembered without receiving new
+// points.
+const staleAfter = time.Hour
+
+type point struct {
+	start uint64
+	value float64
+	// counted is the rounded total already handed out as deltas.
+	counted  int64
+	lastSeen time.Time
+}
+
+// Tracker turns cumulative counters into the deltas that counters in storage
+// expect. The first point of a series only sets the baseline, unless its
+// start time (unix nanoseconds, 0 when unknown) is later than the tracker's
+// creation, in which case the series began counting after the tracker did
+// and is taken whole. A point whose start time moved or whose value went
+// down is a restart and is taken whole too. Delta points are summed per
+// series instead, so their fractions add up rather than being rounded away.
+type Tracker struct {
+	now     func() time.Time
+	created time.Time
+
+	mu        sync.Mutex
+	series    map[string]*point
+	lastPrune time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		now:     time.Now,
+		created: time.Now(),
+		series:  make(map[string]*point),
+	}
+}
+
+type taken struct {
+	key   string
+	start uint64
+	delta int64
+	added float64
+}
+
+// Batch hands out the deltas of one write. Each delta is taken from its
+// series as soon as it is computed, so concurrent writes never count the same
+// increase twice and the tracker stays unlocked while the write is stored.
+// Discard hands the deltas back if the write failed.
+type Batch struct {
+	t     *Tracker
+	now   time.Time
+	taken []taken
+	done  bool
+}
+
+func (t *Tracker) Begin() *Batch {
+	return &Batch{t: t, now: t.now()}
+}
+
+// Delta returns the increase of the series since its previous point.
+// Both sides are rounded before subtracting, so fractional cumulative values
+// add up to the rounded total over time instead of drifting.
+func (b *Batch) Delta(key string, start uint64, value float64) int64 {
+	b.t.mu.Lock()
+	defer b.t.mu.Unlock()
+
+	rounded := int64(math.Round(value))
+	prev, ok := b.t.series[key]
+	b.t.series[key] = &point{start: start, value: value, counted: rounded, lastSeen: b.now}
+
+	var delta int64
+	switch {
+	case !ok && start > uint64(b.t.created.UnixNano()):
+		delta = rounded
+	case !ok:
+		delta = 0
+	case prev.start != start || value < prev.value:
+		delta = rounded
+	default:
+		delta = rounded - prev.counted
+	}
+	b.taken = append(b.taken, taken{key: key, start: start, delta: delta})
+	return delta
+}
+
+// Add adds a delta point to the running total of the series and returns the
+// increase of the rounded total.
+func (b *Batch) Add(key string, value float64) int64 {
+	b.t.mu.Lock()
+	defer b.t.mu.Unlock()
+
+	p, ok := b.t.series[key]
+	if !ok {
+		p = &point{}
+		b.t.series[key] = p
+	}
+	p.value += value
+	p.lastSeen = b.now
+	rounded := int64(math.Round(p.value))
+	delta := rounded - p.counted
+	p.counted = rounded
+
+	b.taken = append(b.taken, taken{key: key, start: p.start, delta: delta, added: value})
+	return delta
+}
+
+// Commit marks the deltas of the batch as stored.
+func (b *Batch) Commit() {
+	if b.done {
+		return
+	}
+	b.done = true
+
+	b.t.mu.Lock()
+	defer b.t.mu.Unlock()
+	b.t.prune(b.now)
+}
+
+// Discard hands the deltas of an unstored batch back to their series, so the
+// next point counts them again. Points that arrived in the meantime keep
+// their own deltas. It is a no-op after Commit.
+func (b *Batch) Discard() {
+	if b.done {
+		return
+	}
+	b.done = true
+
+	b.t.mu.Lock()
+	defer b.t.mu.Unlock()
+	for _, tk := range b.taken {
+		// A restart since then ended the series the delta belonged to.
+		if p, ok := b.t.series[tk.key]; ok && p.start == tk.start {
+			p.counted -= tk.delta
+			p.value -= tk.added
+		}
+	}
+}
+
+func (t *Tracker) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < staleAfter {
+		return
+	}
+	for key, p := range t.series {
+		if now.Sub(p.lastSeen) > staleAfter {
+			delete(t.series, key)
+		}
+	}
+	t.lastPrune = now
+}
@@ -0,0 +1,115 @@
+package cumulative
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker(t *testing.T) {
+	t.Run("First point sets the baseline", func(t *testing.T) {
+		tr := NewTracker()
+		delta := func(start uint64, v float64) int64 {
+			b := tr.Begin()
+			defer b.Commit()
+			return b.Delta("requests", start, v)
+		}
+
+		assert.Equal(t, int64(0), delta(0, 10), "first point only sets the baseline")
+		assert.Equal(t, int64(5), delta(0, 15))
+		assert.Equal(t, int64(0), delta(0, 15))
+		assert.Equal(t, int64(3), delta(0, 3), "a decrease is treated as a reset")
+		assert.Equal(t, int64(4), delta(1, 4), "new start time resets the series")
+	})
+
+	t.Run("Series started after the tracker are taken whole", func(t *testing.T) {
+		tr := NewTracker()
+		b := tr.Begin()
+		defer b.Commit()
+
+		assert.Equal(t, int64(7), b.Delta("new", uint64(tr.created.Add(time.Second).UnixNano()), 7))
+		assert.Equal(t, int64(0), b.Delta("old", uint64(tr.created.Add(-time.Second).UnixNano()), 7))
+	})
+
+	t.Run("Discarded batches are not recorded", func(t *testing.T) {
+		tr := NewTracker()
+		b := tr.Begin()
+		b.Delta("requests", 0, 10)
+		b.Commit()
+
+		b = tr.Begin()
+		assert.Equal(t, int64(5), b.Delta("requests", 0, 15))
+		assert.Equal(t, int64(2), b.Delta("requests", 0, 17), "points within a batch build on each other")
+		b.Discard()
+
+		b = tr.Begin()
+		assert.Equal(t, int64(7), b.Delta("requests", 0, 17), "the failed write is counted again")
+		b.Commit()
+		b.Discard()
+	})
+
+	t.Run("Overlapping batches", func(t *testing.T) {
+		tr := NewTracker()
+		b := tr.Begin()
+		b.Delta("requests", 0, 10)
+		b.Commit()
+
+		first, second := tr.Begin(), tr.Begin()
+		assert.Equal(t, int64(5), first.Delta("requests", 0, 15))
+		assert.Equal(t, int64(2), second.Delta("requests", 0, 17), "an open batch does not block or share its increase")
+		first.Discard()
+		second.Commit()
+
+		b = tr.Begin()
+		assert.Equal(t, int64(8), b.Delta("requests", 0, 20), "the failed increase is counted again, the stored one is not")
+		b.Commit()
+
+		b = tr.Begin()
+		b.Delta("requests", 1, 4)
+		first = tr.Begin()
+		assert.Equal(t, int64(2), first.Delta("requests", 1, 6))
+		second = tr.Begin()
+		assert.Equal(t, int64(3), second.Delta("requests", 2, 3))
+		first.Discard()
+		b.Commit()
+		second.Commit()
+
+		b = tr.Begin()
+		assert.Equal(t, int64(1), b.Delta("requests", 2, 4), "increases from before a restart are not handed back")
+		b.Commit()
+	})
+
+	t.Run("Delta points add up", func(t *testing.T) {
+		tr := NewTracker()
+		add := func(v float64) int64 {
+			b := tr.Begin()
+			defer b.Commit()
+			return b.Add("requests", v)
+		}
+
+		assert.Equal(t, int64(0), add(0.4))
+		assert.Equal(t, int64(1), add(0.4), "fractions are carried, not rounded away")
+		assert.Equal(t, int64(0), add(0.4))
+
+		b := tr.Begin()
+		assert.Equal(t, int64(2), b.Add("requests", 1.6))
+		b.Discard()
+		assert.Equal(t, int64(2), add(1.6), "a failed write takes its point back")
+	})
+
+	t.Run("Stale series are forgotten", func(t *testing.T) {
+		tr := NewTracker()
+		now := time.Now()
+		tr.now = func() time.Time { return now }
+
+		b := tr.Begin()
+		b.Delta("requests", 0, 10)
+		b.Commit()
+
+		now = now.Add(2 * staleAfter)
+		b = tr.Begin()
+		b.Commit()
+		assert.Empty(t, tr.series)
+	})
+}
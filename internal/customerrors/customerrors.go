@@ -7,10 +7,11 @@ import (
 )
 
 var (
-	ErrInvalidValue = errors.New("invalid value")
-	ErrKeyNotFound  = errors.New("key not found")
-	ErrInvalidType  = errors.New("invalid metric type")
-	ErrNotConnected = errors.New("database not connected")
+	ErrInvalidValue  = errors.New("invalid value")
+	ErrKeyNotFound   = errors.New("key not found")
+	ErrInvalidType   = errors.New("invalid metric type")
+	ErrNotConnected  = errors.New("database not connected")
+	ErrInvalidLabels = errors.New("invalid labels")
 )
 
 type CommonError struct {
@@ -40,6 +41,8 @@ func statusText(status int) (title, detail string) {
 	switch status {
 	case http.StatusBadRequest:
 		return "Validation Error", "The request could not be understood or was missing required parameters"
+	case http.StatusForbidden:
+		return "Forbidden", "The request did not originate from a trusted network"
 	case http.StatusNotFound:
 		return "Not Found", "The requested resource could not be found"
 	case http.StatusInternalServerError:
@@ -2,8 +2,8 @@ package db
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"sync"
 	"time"
@@ -18,7 +18,14 @@ type MetricsStorage interface {
 	SetCounter(ctx context.Context, name string, value int64) error
 	GetCounter(ctx context.Context, name string) (int64, error)
 	GetAll(ctx context.Context) (map[string]float64, map[string]int64, error)
+	SetHistogram(ctx context.Context, name string, value *model.Histogram) error
+	GetHistogram(ctx context.Context, name string) (*model.Histogram, error)
+	GetHistograms(ctx context.Context) (map[string]*model.Histogram, error)
+	SetSummary(ctx context.Context, name string, value *model.Summary) error
+	GetSummary(ctx context.Context, name string) (*model.Summary, error)
+	GetSummaries(ctx context.Context) (map[string]*model.Summary, error)
 	UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error
+	QueryRange(ctx context.Context, name, mtype string, from, to time.Time, step time.Duration) ([]*model.Series, error)
 	Ping(ctx context.Context) error
 	Close() error
 }
@@ -27,23 +34,41 @@ type Store struct {
 	mu            sync.RWMutex
 	gauges        map[string]float64
 	counters      map[string]int64
+	histograms    map[string]*model.Histogram
+	summaries     map[string]*model.Summary
 	filePath      string
 	storeInterval time.Duration
 	syncMode      bool
+	compress      bool
 	saveMutex     sync.Mutex
 	ticker        *time.Ticker
 	closeChan     chan struct{}
 	closed        bool
+
+	// In interval mode every update is also appended to a write-ahead log so
+	// that a crash between snapshots loses nothing.
+	walEnabled bool
+	wal        *os.File
+	walSeq     uint64
+
+	history          map[seriesRef][]model.Point
+	historyRetention time.Duration
 }
 
 func NewStore(filePath string, storeInterval time.Duration) *Store {
 	s := &Store{
 		gauges:        make(map[string]float64),
 		counters:      make(map[string]int64),
+		histograms:    make(map[string]*model.Histogram),
+		summaries:     make(map[string]*model.Summary),
 		filePath:      filePath,
 		storeInterval: storeInterval,
 		syncMode:      storeInterval == 0,
+		walEnabled:    storeInterval > 0 && filePath != "",
 		closeChan:     make(chan struct{}),
+
+		history:          make(map[seriesRef][]model.Point),
+		historyRetention: DefaultHistoryRetention,
 	}
 
 	if !s.syncMode && storeInterval > 0 {
@@ -58,6 +83,14 @@ func NewStore(filePath string, storeInterval time.Duration) *Store {
 	return s
 }
 
+// SetCompression gzips snapshots written from now on. Loading detects the
+// format on its own, so the setting can be flipped on existing files.
+func (s *Store) SetCompression(enabled bool) {
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+	s.compress = enabled
+}
+
 func (s *Store) periodicSave() {
 	for {
 		select {
@@ -77,16 +110,7 @@ func (s *Store) periodicSave() {
 }
 
 func (s *Store) SetGauge(ctx context.Context, name string, value float64) error {
-	s.mu.Lock()
-	s.gauges[name] = value
-	s.mu.Unlock()
-
-	if s.syncMode && s.filePath != "" {
-		s.saveMutex.Lock()
-		defer s.saveMutex.Unlock()
-		return s.saveToFile()
-	}
-	return nil
+	return s.update(walOp{Type: model.GaugeType, Key: name, Value: &value})
 }
 
 func (s *Store) GetGauge(ctx context.Context, name string) (float64, error) {
@@ -100,20 +124,7 @@ func (s *Store) GetGauge(ctx context.Context, name string) (float64, error) {
 }
 
 func (s *Store) SetCounter(ctx context.Context, name string, value int64) error {
-	s.mu.Lock()
-	current, ok := s.counters[name]
-	if !ok {
-		current = 0
-	}
-	s.counters[name] = current + value
-	s.mu.Unlock()
-
-	if s.syncMode && s.filePath != "" {
-		s.saveMutex.Lock()
-		defer s.saveMutex.Unlock()
-		return s.saveToFile()
-	}
-	return nil
+	return s.update(walOp{Type: model.CounterType, Key: name, Delta: &value})
 }
 
 func (s *Store) GetCounter(ctx context.Context, name string) (int64, error) {
@@ -143,13 +154,73 @@ func (s *Store) GetAll(ctx context.Context) (map[string]float64, map[string]int6
 	return gaugesCopy, countersCopy, nil
 }
 
+func (s *Store) SetHistogram(ctx context.Context, name string, value *model.Histogram) error {
+	return s.update(walOp{Type: model.HistogramType, Key: name, Histogram: value.Clone()})
+}
+
+func (s *Store) GetHistogram(ctx context.Context, name string) (*model.Histogram, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if value, ok := s.histograms[name]; ok {
+		return value.Clone(), nil
+	}
+	return nil, customerrors.ErrKeyNotFound
+}
+
+func (s *Store) GetHistograms(ctx context.Context) (map[string]*model.Histogram, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]*model.Histogram, len(s.histograms))
+	for k, v := range s.histograms {
+		result[k] = v.Clone()
+	}
+	return result, nil
+}
+
+func (s *Store) SetSummary(ctx context.Context, name string, value *model.Summary) error {
+	return s.update(walOp{Type: model.SummaryType, Key: name, Summary: value.Clone()})
+}
+
+func (s *Store) GetSummary(ctx context.Context, name string) (*model.Summary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if value, ok := s.summaries[name]; ok {
+		return value.Clone(), nil
+	}
+	return nil, customerrors.ErrKeyNotFound
+}
+
+func (s *Store) GetSummaries(ctx context.Context) (map[string]*model.Summary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]*model.Summary, len(s.summaries))
+	for k, v := range s.summaries {
+		result[k] = v.Clone()
+	}
+	return result, nil
+}
+
 func (s *Store) Close() error {
+	s.mu.Lock()
 	s.closed = true
+	s.mu.Unlock()
 	close(s.closeChan)
 
 	s.saveMutex.Lock()
 	defer s.saveMutex.Unlock()
-	return s.saveToFile()
+	err := s.saveToFile()
+
+	s.mu.Lock()
+	if s.wal != nil {
+		s.wal.Close()
+		s.wal = nil
+	}
+	s.mu.Unlock()
+	return err
 }
 
 func (s *Store) SaveToFile() error {
@@ -164,6 +235,7 @@ func (s *Store) saveToFile() error {
 	}
 
 	s.mu.RLock()
+	seq := s.walSeq
 	gaugeCopy := make(map[string]float64, len(s.gauges))
 	for k, v := range s.gauges {
 		gaugeCopy[k] = v
@@ -172,29 +244,34 @@ func (s *Store) saveToFile() error {
 	for k, v := range s.counters {
 		counterCopy[k] = v
 	}
+	histogramCopy := make(map[string]*model.Histogram, len(s.histograms))
+	for k, v := range s.histograms {
+		histogramCopy[k] = v.Clone()
+	}
+	summaryCopy := make(map[string]*model.Summary, len(s.summaries))
+	for k, v := range s.summaries {
+		summaryCopy[k] = v.Clone()
+	}
 	s.mu.RUnlock()
 
-	data := struct {
-		Gauges   map[string]float64 `json:"gauges"`
-		Counters map[string]int64   `json:"counters"`
-	}{
-		Gauges:   gaugeCopy,
-		Counters: counterCopy,
+	data := snapshot{
+		Gauges:     gaugeCopy,
+		Counters:   counterCopy,
+		Histograms: histogramCopy,
+		Summaries:  summaryCopy,
+		Seq:        seq,
 	}
 
-	file, err := os.Create(s.filePath)
+	err := writeFileAtomic(s.filePath, func(w io.Writer) error {
+		return encodeSnapshot(w, data, s.compress)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return fmt.Errorf("failed to save snapshot: %w", err)
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(data); err != nil {
-		return fmt.Errorf("failed to encode data: %w", err)
+	if s.walEnabled {
+		return s.compactWAL(seq)
 	}
-
-	fmt.Println("[DEBUG] saveToFile completed")
 	return nil
 }
 
@@ -206,52 +283,104 @@ func (s *Store) LoadFromFile() error {
 		return nil
 	}
 
-	if _, err := os.Stat(s.filePath); os.IsNotExist(err) {
-		return nil
-	}
-
+	var data snapshot
 	file, err := os.Open(s.filePath)
-	if err != nil {
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
 		return fmt.Errorf("failed to open file: %w", err)
+	default:
+		defer file.Close()
+		if data, err = decodeSnapshot(file); err != nil {
+			return err
+		}
 	}
-	defer file.Close()
 
-	var data struct {
-		Gauges   map[string]float64 `json:"gauges"`
-		Counters map[string]int64   `json:"counters"`
+	records, err := readWAL(s.walPath(), data.Seq)
+	if err != nil {
+		return err
 	}
 
-	if err := json.NewDecoder(file).Decode(&data); err != nil {
-		return fmt.Errorf("failed to decode data: %w", err)
+	if data.Gauges == nil {
+		data.Gauges = make(map[string]float64)
+	}
+	if data.Counters == nil {
+		data.Counters = make(map[string]int64)
+	}
+	if data.Histograms == nil {
+		data.Histograms = make(map[string]*model.Histogram)
+	}
+	if data.Summaries == nil {
+		data.Summaries = make(map[string]*model.Summary)
 	}
 
 	s.mu.Lock()
 	s.gauges = data.Gauges
 	s.counters = data.Counters
+	s.histograms = data.Histograms
+	s.summaries = data.Summaries
+	s.walSeq = data.Seq
+	for _, record := range records {
+		for _, op := range record.Ops {
+			s.apply(op)
+		}
+		s.walSeq = record.Seq
+	}
 	s.mu.Unlock()
 
 	return nil
 }
 
 func (s *Store) UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error {
-	s.mu.Lock()
+	ops := make([]walOp, 0, len(metrics))
 	for _, m := range metrics {
+		key := m.Key()
 		switch m.MType {
 		case model.GaugeType:
-			if m.Value == nil {
-				continue
+			if m.Value != nil {
+				ops = append(ops, walOp{Type: model.GaugeType, Key: key, Value: m.Value})
 			}
-			s.gauges[m.ID] = *m.Value
 		case model.CounterType:
-			if m.Delta == nil {
-				continue
+			if m.Delta != nil {
+				ops = append(ops, walOp{Type: model.CounterType, Key: key, Delta: m.Delta})
+			}
+		case model.HistogramType:
+			if m.Histogram != nil {
+				ops = append(ops, walOp{Type: model.HistogramType, Key: key, Histogram: m.Histogram.Clone()})
+			}
+		case model.SummaryType:
+			if m.Summary != nil {
+				ops = append(ops, walOp{Type: model.SummaryType, Key: key, Summary: m.Summary.Clone()})
 			}
-			s.counters[m.ID] += *m.Delta
 		default:
-			s.mu.Unlock()
 			return fmt.Errorf("unsupported metric type: %s", m.MType)
 		}
 	}
+	return s.update(ops...)
+}
+
+// update logs the ops before applying them, so nothing that failed to reach
+// the WAL is ever visible in memory.
+func (s *Store) update(ops ...walOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	if err := s.appendWAL(ops...); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	for _, op := range ops {
+		s.apply(op)
+		switch op.Type {
+		case model.GaugeType:
+			s.recordSample(model.GaugeType, op.Key, s.gauges[op.Key], now)
+		case model.CounterType:
+			s.recordSample(model.CounterType, op.Key, float64(s.counters[op.Key]), now)
+		}
+	}
 	s.mu.Unlock()
 
 	if s.syncMode && s.filePath != "" {
@@ -259,7 +388,6 @@ func (s *Store) UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics
 		defer s.saveMutex.Unlock()
 		return s.saveToFile()
 	}
-
 	return nil
 }
 
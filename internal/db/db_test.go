@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -100,6 +101,66 @@ func TestStore(t *testing.T) {
 		assert.Equal(t, int64(10), counters["counter1"])
 	})
 
+	t.Run("Batch with labels", func(t *testing.T) {
+		ctx := context.Background()
+		store := NewStore("", 0)
+		defer store.Close()
+
+		one, two := int64(1), int64(2)
+		err := store.UpdateMetricsBatch(ctx, []*model.Metrics{
+			{ID: "PollCount", MType: model.CounterType, Delta: &one, Labels: model.Labels{"host": "a"}},
+			{ID: "PollCount", MType: model.CounterType, Delta: &two, Labels: model.Labels{"host": "b"}},
+			{ID: "PollCount", MType: model.CounterType, Delta: &two, Labels: model.Labels{"host": "a"}},
+		})
+		require.NoError(t, err)
+
+		a, err := store.GetCounter(ctx, model.SeriesKey("PollCount", model.Labels{"host": "a"}))
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), a)
+
+		b, err := store.GetCounter(ctx, model.SeriesKey("PollCount", model.Labels{"host": "b"}))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), b)
+
+		_, err = store.GetCounter(ctx, "PollCount")
+		assert.ErrorIs(t, err, customerrors.ErrKeyNotFound)
+	})
+
+	t.Run("Histograms and summaries", func(t *testing.T) {
+		ctx := context.Background()
+		path := t.TempDir() + "/store.json"
+		store := NewStore(path, 0)
+
+		h := model.NewHistogram([]float64{0.1, 1})
+		h.Observe(0.05)
+		require.NoError(t, store.SetHistogram(ctx, "latency", h))
+		h.Observe(5)
+
+		sum := &model.Summary{Quantiles: []model.Quantile{{Quantile: 0.5, Value: 1}}, Sum: 3, Count: 2}
+		require.NoError(t, store.UpdateMetricsBatch(ctx, []*model.Metrics{
+			{ID: "rpc", MType: model.SummaryType, Summary: sum, Labels: model.Labels{"host": "a"}},
+		}))
+		require.NoError(t, store.Close())
+
+		restored := NewStore(path, 0)
+		defer restored.Close()
+
+		got, err := restored.GetHistogram(ctx, "latency")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), got.Count, "stored histogram must not alias the caller's value")
+
+		gotSum, err := restored.GetSummary(ctx, `rpc{host="a"}`)
+		require.NoError(t, err)
+		assert.Equal(t, sum, gotSum)
+
+		all, err := restored.GetHistograms(ctx)
+		require.NoError(t, err)
+		assert.Len(t, all, 1)
+
+		_, err = restored.GetSummary(ctx, "rpc")
+		assert.ErrorIs(t, err, customerrors.ErrKeyNotFound)
+	})
+
 	t.Run("Concurrent access", func(t *testing.T) {
 		ctx := context.Background()
 		store := NewStore("", 0)
@@ -149,6 +210,7 @@ func TestStore(t *testing.T) {
 		tempPath := tempFile.Name()
 		tempFile.Close()
 		defer os.Remove(tempPath)
+		defer os.Remove(tempPath + ".wal")
 
 		store := NewStore(tempPath, 10*time.Millisecond)
 
@@ -170,4 +232,83 @@ func TestStore(t *testing.T) {
 
 		require.NoError(t, store.Close())
 	})
+
+	t.Run("Write-ahead log", func(t *testing.T) {
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "metrics.json")
+
+		store := NewStore(path, time.Hour)
+		defer store.Close()
+
+		require.NoError(t, store.SetGauge(ctx, "gauge", 1.5))
+		require.NoError(t, store.SetCounter(ctx, "counter", 2))
+		delta := int64(3)
+		require.NoError(t, store.UpdateMetricsBatch(ctx, []*model.Metrics{{ID: "counter", MType: model.CounterType, Delta: &delta}}))
+
+		// Nothing has been snapshotted yet, so a restart must rebuild the state from the log alone.
+		recovered := NewStore(path, 0)
+		gauge, err := recovered.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, 1.5, gauge)
+		counter, err := recovered.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), counter)
+		require.NoError(t, recovered.Close())
+
+		require.NoError(t, store.SaveToFile())
+		_, err = os.Stat(path + ".wal")
+		assert.True(t, os.IsNotExist(err), "snapshot compacts the log")
+
+		require.NoError(t, store.SetCounter(ctx, "counter", 1))
+		f, err := os.OpenFile(path+".wal", os.O_APPEND|os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		_, err = f.WriteString(`{"seq":99,"ops":[{"type":"cou`)
+		require.NoError(t, err)
+		f.Close()
+
+		recovered = NewStore(path, 0)
+		defer recovered.Close()
+		counter, err = recovered.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), counter, "snapshot plus log tail, torn record ignored")
+
+		files, err := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp-*"))
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("Failed log writes change nothing", func(t *testing.T) {
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "metrics.json")
+
+		store := NewStore(path, time.Hour)
+		defer store.Close()
+		require.NoError(t, os.Mkdir(path+".wal", 0o755), "a directory in place of the log makes appends fail")
+
+		assert.Error(t, store.SetGauge(ctx, "gauge", 1))
+		assert.Error(t, store.SetCounter(ctx, "counter", 1))
+		delta := int64(1)
+		assert.Error(t, store.UpdateMetricsBatch(ctx, []*model.Metrics{{ID: "batch", MType: model.CounterType, Delta: &delta}}))
+
+		gauges, counters, err := store.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, gauges)
+		assert.Empty(t, counters)
+	})
+
+	t.Run("Batch with an unsupported type is rejected whole", func(t *testing.T) {
+		ctx := context.Background()
+		store := NewStore("", 0)
+		defer store.Close()
+
+		delta := int64(1)
+		err := store.UpdateMetricsBatch(ctx, []*model.Metrics{
+			{ID: "rejected", MType: model.CounterType, Delta: &delta},
+			{ID: "bad", MType: "unknown"},
+		})
+		assert.Error(t, err)
+
+		_, err = store.GetCounter(ctx, "rejected")
+		assert.ErrorIs(t, err, customerrors.ErrKeyNotFound)
+	})
 }
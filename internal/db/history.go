@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/model"
+)
+
+const DefaultHistoryRetention = time.Hour
+
+// HistoryPruneInterval is how often expired PostgreSQL history is deleted.
+const HistoryPruneInterval = time.Minute
+
+type seriesRef struct {
+	mtype string
+	key   string
+}
+
+func (s *Store) SetHistoryRetention(retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.historyRetention = retention
+}
+
+func (s *Store) recordSample(mtype, key string, value float64, now time.Time) {
+	if s.historyRetention <= 0 {
+		return
+	}
+
+	ref := seriesRef{mtype: mtype, key: key}
+	points := append(s.history[ref], model.Point{Timestamp: now, Value: value})
+
+	cutoff := now.Add(-s.historyRetention)
+	drop := sort.Search(len(points), func(i int) bool {
+		return points[i].Timestamp.After(cutoff)
+	})
+	if drop > 0 {
+		points = append(points[:0:0], points[drop:]...)
+	}
+	s.history[ref] = points
+}
+
+func (s *Store) QueryRange(ctx context.Context, name, mtype string, from, to time.Time, step time.Duration) ([]*model.Series, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*model.Series
+	for ref, points := range s.history {
+		if ref.mtype != mtype {
+			continue
+		}
+		id, labels, err := model.ParseSeriesKey(ref.key)
+		if err != nil || id != name {
+			continue
+		}
+
+		downsampled := downsample(points, from, to, step)
+		if len(downsampled) == 0 {
+			continue
+		}
+		result = append(result, &model.Series{
+			ID:     id,
+			MType:  mtype,
+			Labels: labels,
+			Points: downsampled,
+		})
+	}
+
+	sortSeries(result)
+	return result, nil
+}
+
+func sortSeries(series []*model.Series) {
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Labels.String() < series[j].Labels.String()
+	})
+}
+
+func downsample(points []model.Point, from, to time.Time, step time.Duration) []model.Point {
+	start := sort.Search(len(points), func(i int) bool {
+		return !points[i].Timestamp.Before(from)
+	})
+
+	var result []model.Point
+	if step <= 0 {
+		for _, p := range points[start:] {
+			if p.Timestamp.After(to) {
+				break
+			}
+			result = append(result, p)
+		}
+		return result
+	}
+
+	i := start
+	for t := from; !t.After(to); t = t.Add(step) {
+		var last *model.Point
+		for i < len(points) && !points[i].Timestamp.After(t) {
+			last = &points[i]
+			i++
+		}
+		if last != nil {
+			result = append(result, model.Point{Timestamp: t, Value: last.Value})
+		}
+	}
+	return result
+}
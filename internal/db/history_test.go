@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownsample(t *testing.T) {
+	base := time.Unix(1000, 0)
+	points := []model.Point{
+		{Timestamp: base.Add(5 * time.Second), Value: 1},
+		{Timestamp: base.Add(15 * time.Second), Value: 2},
+		{Timestamp: base.Add(18 * time.Second), Value: 3},
+		{Timestamp: base.Add(45 * time.Second), Value: 4},
+	}
+
+	t.Run("Raw points in range", func(t *testing.T) {
+		got := downsample(points, base.Add(10*time.Second), base.Add(20*time.Second), 0)
+		assert.Equal(t, points[1:3], got)
+	})
+
+	t.Run("Last point per step", func(t *testing.T) {
+		got := downsample(points, base, base.Add(time.Minute), 20*time.Second)
+		assert.Equal(t, []model.Point{
+			{Timestamp: base.Add(20 * time.Second), Value: 3},
+			{Timestamp: base.Add(60 * time.Second), Value: 4},
+		}, got)
+	})
+
+	t.Run("Empty range", func(t *testing.T) {
+		assert.Empty(t, downsample(points, base.Add(time.Hour), base.Add(2*time.Hour), time.Minute))
+	})
+}
+
+func TestStore_QueryRange(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore("", 0)
+	defer store.Close()
+
+	start := time.Now().Add(-time.Second)
+
+	require.NoError(t, store.SetGauge(ctx, "Alloc", 1))
+	require.NoError(t, store.SetGauge(ctx, "Alloc", 2))
+	require.NoError(t, store.SetCounter(ctx, "PollCount", 5))
+	require.NoError(t, store.SetCounter(ctx, "PollCount", 5))
+
+	v := 7.0
+	require.NoError(t, store.UpdateMetricsBatch(ctx, []*model.Metrics{
+		{ID: "Alloc", MType: model.GaugeType, Value: &v, Labels: model.Labels{"host": "a"}},
+	}))
+
+	end := time.Now().Add(time.Second)
+
+	gauges, err := store.QueryRange(ctx, "Alloc", model.GaugeType, start, end, 0)
+	require.NoError(t, err)
+	require.Len(t, gauges, 2)
+	assert.Nil(t, gauges[0].Labels)
+	require.Len(t, gauges[0].Points, 2)
+	assert.Equal(t, 1.0, gauges[0].Points[0].Value)
+	assert.Equal(t, 2.0, gauges[0].Points[1].Value)
+	assert.Equal(t, model.Labels{"host": "a"}, gauges[1].Labels)
+
+	counters, err := store.QueryRange(ctx, "PollCount", model.CounterType, start, end, 0)
+	require.NoError(t, err)
+	require.Len(t, counters, 1)
+	require.Len(t, counters[0].Points, 2)
+	assert.Equal(t, 10.0, counters[0].Points[1].Value, "counter history must hold cumulative values")
+
+	none, err := store.QueryRange(ctx, "PollCount", model.GaugeType, start, end, 0)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+}
+
+func TestStore_HistoryRetention(t *testing.T) {
+	store := NewStore("", 0)
+	defer store.Close()
+	store.SetHistoryRetention(time.Minute)
+
+	base := time.Now()
+	store.mu.Lock()
+	store.recordSample(model.GaugeType, "Alloc", 1, base.Add(-2*time.Minute))
+	store.recordSample(model.GaugeType, "Alloc", 2, base.Add(-30*time.Second))
+	store.recordSample(model.GaugeType, "Alloc", 3, base)
+	store.mu.Unlock()
+
+	series, err := store.QueryRange(context.Background(), "Alloc", model.GaugeType, base.Add(-time.Hour), base, 0)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	assert.Equal(t, []model.Point{
+		{Timestamp: base.Add(-30 * time.Second), Value: 2},
+		{Timestamp: base, Value: 3},
+	}, series[0].Points)
+
+	store.SetHistoryRetention(0)
+	require.NoError(t, store.SetGauge(context.Background(), "Other", 1))
+	series, err = store.QueryRange(context.Background(), "Other", model.GaugeType, base.Add(-time.Hour), time.Now(), 0)
+	require.NoError(t, err)
+	assert.Empty(t, series, "zero retention disables history")
+}
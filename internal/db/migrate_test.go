@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "versions are contiguous and ordered")
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+}
+
+func expectLocked(mock sqlmock.Sqlmock, applied ...int) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_lock($1)`)).
+		WithArgs(migrationLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS schema_migrations`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, version := range applied {
+		rows.AddRow(version, time.Unix(0, 0))
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT version, applied_at FROM schema_migrations`)).WillReturnRows(rows)
+}
+
+func expectUnlocked(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).
+		WithArgs(migrationLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+	latest := migrations[len(migrations)-1]
+
+	t.Run("Up applies pending migrations only", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		expectLocked(mock, 1)
+		for _, m := range migrations[1:] {
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(m.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`)).
+				WithArgs(m.Version, m.Name).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+		}
+		expectUnlocked(mock)
+
+		migrator, err := NewMigrator(db)
+		require.NoError(t, err)
+		applied, err := migrator.Up(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, len(migrations)-1, applied)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Failed migration rolls back and releases the lock", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		expectLocked(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(migrations[0].Up)).WillReturnError(errors.New("syntax error"))
+		mock.ExpectRollback()
+		expectUnlocked(mock)
+
+		migrator, err := NewMigrator(db)
+		require.NoError(t, err)
+		applied, err := migrator.Up(ctx)
+		assert.ErrorContains(t, err, "syntax error")
+		assert.Zero(t, applied)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Down reverts the latest migration", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		var all []int
+		for _, m := range migrations {
+			all = append(all, m.Version)
+		}
+		expectLocked(mock, all...)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(latest.Down)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM schema_migrations WHERE version = $1`)).
+			WithArgs(latest.Version).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectUnlocked(mock)
+
+		migrator, err := NewMigrator(db)
+		require.NoError(t, err)
+		reverted, err := migrator.Down(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, 1, reverted)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		expectLocked(mock, 1)
+		expectUnlocked(mock)
+
+		migrator, err := NewMigrator(db)
+		require.NoError(t, err)
+		statuses, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		require.Len(t, statuses, len(migrations))
+		assert.True(t, statuses[0].Applied)
+		assert.False(t, statuses[len(statuses)-1].Applied)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
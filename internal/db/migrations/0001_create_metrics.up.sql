@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS metrics (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    mtype VARCHAR(10) NOT NULL,
+    labels TEXT NOT NULL DEFAULT '',
+    delta BIGINT,
+    value DOUBLE PRECISION
+);
+
+-- Tables created before labels existed carry a (name, mtype) constraint.
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels TEXT NOT NULL DEFAULT '';
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_name_mtype_key;
+CREATE UNIQUE INDEX IF NOT EXISTS metrics_name_mtype_labels_key ON metrics (name, mtype, labels);
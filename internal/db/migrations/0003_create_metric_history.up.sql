@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS metric_history (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    mtype VARCHAR(10) NOT NULL,
+    labels TEXT NOT NULL DEFAULT '',
+    ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+    value DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS metric_history_name_mtype_ts_idx ON metric_history (name, mtype, ts);
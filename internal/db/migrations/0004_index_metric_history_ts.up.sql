@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS metric_history_ts_idx ON metric_history (ts);
@@ -3,10 +3,11 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -15,195 +16,306 @@ import (
 	"github.com/Heidric/metrics.git/internal/model"
 )
 
+// PoolConfig tunes the database/sql connection pool. Zero values keep the
+// database/sql defaults.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// PostgresStore connects lazily on first use. Once open, the *sql.DB is never
+// replaced: database/sql already discards broken connections and dials new
+// ones, so queries run concurrently without any store-level locking. After
+// Close every call fails with ErrNotConnected instead of reconnecting.
 type PostgresStore struct {
 	dsn       string
-	db        *sql.DB
-	mu        sync.Mutex
-	connected bool
+	pool      PoolConfig
+	retention time.Duration
+	db        atomic.Pointer[sql.DB]
+	connectMu sync.Mutex
 	closeOnce sync.Once
+	closed    atomic.Bool
 }
 
 func NewPostgresStore(dsn string) *PostgresStore {
 	return &PostgresStore{dsn: dsn}
 }
 
-func (p *PostgresStore) resetConnection() {
-	log.Println("Resetting connection")
-
-	if p.db != nil {
-		log.Println("Closing database connection")
-		p.db.Close()
-		p.db = nil
-	}
-	p.connected = false
-
-	log.Println("Connection reset complete")
+func (p *PostgresStore) SetPoolConfig(pool PoolConfig) {
+	p.pool = pool
 }
 
-func (p *PostgresStore) handleConnectionError(err error) {
-	log.Printf("Handling connection error: %v", err)
-	p.resetConnection()
+// SetHistoryRetention sets how long PruneHistory keeps metric_history rows.
+// Zero keeps them forever.
+func (p *PostgresStore) SetHistoryRetention(retention time.Duration) {
+	p.retention = retention
 }
 
-func (p *PostgresStore) ensureConnected(ctx context.Context) error {
-	log.Println("Ensuring connection")
+func (p *PostgresStore) conn(ctx context.Context) (*sql.DB, error) {
+	if db := p.db.Load(); db != nil {
+		return db, nil
+	}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.connectMu.Lock()
+	defer p.connectMu.Unlock()
 
-	if p.connected {
-		log.Println("Already connected, skipping")
-		return nil
+	if db := p.db.Load(); db != nil {
+		return db, nil
 	}
 
-	if p.dsn == "" {
-		return customerrors.ErrNotConnected
+	if p.dsn == "" || p.closed.Load() {
+		return nil, customerrors.ErrNotConnected
 	}
 
 	log.Println("Opening new database connection")
 	db, err := sql.Open("pgx", p.dsn)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if p.pool.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(p.pool.MaxOpenConns)
+	}
+	if p.pool.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(p.pool.MaxIdleConns)
+	}
+	if p.pool.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.pool.ConnMaxLifetime)
+	}
+	if p.pool.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(p.pool.ConnMaxIdleTime)
 	}
 
-	log.Println("Pinging database")
 	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	if err := db.PingContext(pingCtx); err != nil {
 		db.Close()
-		return fmt.Errorf("database ping failed: %w", err)
+		return nil, fmt.Errorf("database ping failed: %w", err)
 	}
 
-	log.Println("Creating table")
-	if err := p.createTable(db); err != nil {
+	if err := p.migrate(ctx, db); err != nil {
 		db.Close()
-		return fmt.Errorf("failed to create table: %w", err)
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
 	}
 
 	log.Println("Connection established successfully")
-	p.db = db
-	p.connected = true
-	return nil
+	p.db.Store(db)
+	return db, nil
 }
 
-func (p *PostgresStore) createTable(db *sql.DB) error {
-	query := `
-        CREATE TABLE IF NOT EXISTS metrics (
-            id SERIAL PRIMARY KEY,
-            name VARCHAR(255) NOT NULL,
-            mtype VARCHAR(10) NOT NULL,
-            delta BIGINT,
-            value DOUBLE PRECISION,
-            UNIQUE (name, mtype)
-        )
-    `
-	_, err := db.Exec(query)
+func (p *PostgresStore) migrate(ctx context.Context, db *sql.DB) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up(ctx)
+	if applied > 0 {
+		log.Printf("Applied %d schema migrations", applied)
+	}
 	return err
 }
 
+func splitSeriesKey(key string) (string, string) {
+	name, labels, err := model.ParseSeriesKey(key)
+	if err != nil {
+		return key, ""
+	}
+	return name, labels.String()
+}
+
 func (p *PostgresStore) SetGauge(ctx context.Context, name string, value float64) error {
-	return withPGRetry(func() error {
-		if err := p.ensureConnected(ctx); err != nil {
-			log.Printf("ensureConnected error: %v", err)
+	return withPGRetry(ctx, func() error {
+		db, err := p.conn(ctx)
+		if err != nil {
 			return err
 		}
 
-		p.mu.Lock()
-		defer p.mu.Unlock()
-
+		metricName, labels := splitSeriesKey(name)
 		query := `
-	        INSERT INTO metrics (name, mtype, value)
-	        VALUES ($1, 'gauge', $2)
-	        ON CONFLICT (name, mtype) DO UPDATE SET value = $2
+	        WITH upserted AS (
+	            INSERT INTO metrics (name, mtype, labels, value)
+	            VALUES ($1, 'gauge', $2, $3)
+	            ON CONFLICT (name, mtype, labels) DO UPDATE SET value = $3
+	            RETURNING name, mtype, labels, value
+	        )
+	        INSERT INTO metric_history (name, mtype, labels, value)
+	        SELECT name, mtype, labels, value FROM upserted
 	    `
-		_, err := p.db.ExecContext(ctx, query, name, value)
-		if err != nil {
-			log.Printf("Query error: %v", err)
-			if err == sql.ErrConnDone || err == sql.ErrTxDone || strings.Contains(strings.ToLower(err.Error()), "connection") {
-				log.Println("Detected connection error, resetting...")
-				p.resetConnection()
-			}
-		}
+		_, err = db.ExecContext(ctx, query, metricName, labels, value)
 		return err
 	})
 }
 
 func (p *PostgresStore) SetCounter(ctx context.Context, name string, value int64) error {
-	return withPGRetry(func() error {
-		if err := p.ensureConnected(ctx); err != nil {
+	return withPGRetry(ctx, func() error {
+		db, err := p.conn(ctx)
+		if err != nil {
 			return err
 		}
 
-		p.mu.Lock()
-		defer p.mu.Unlock()
-
+		metricName, labels := splitSeriesKey(name)
 		query := `
-	        INSERT INTO metrics (name, mtype, delta)
-	        VALUES ($1, 'counter', $2)
-	        ON CONFLICT (name, mtype) DO UPDATE SET delta = metrics.delta + $2
+	        WITH upserted AS (
+	            INSERT INTO metrics (name, mtype, labels, delta)
+	            VALUES ($1, 'counter', $2, $3)
+	            ON CONFLICT (name, mtype, labels) DO UPDATE SET delta = metrics.delta + $3
+	            RETURNING name, mtype, labels, delta
+	        )
+	        INSERT INTO metric_history (name, mtype, labels, value)
+	        SELECT name, mtype, labels, delta FROM upserted
 	    `
-		_, err := p.db.ExecContext(ctx, query, name, value)
+		_, err = db.ExecContext(ctx, query, metricName, labels, value)
 		return err
 	})
 }
 
-func (p *PostgresStore) UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error {
-	return withPGRetry(func() error {
-		if err := p.ensureConnected(ctx); err != nil {
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func execPayloadUpsert(ctx context.Context, db execer, name, mtype, labels string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal %s payload: %w", mtype, err)
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO metrics (name, mtype, labels, payload)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name, mtype, labels) DO UPDATE SET payload = $4
+	`, name, mtype, labels, data)
+	return err
+}
+
+func (p *PostgresStore) setPayload(ctx context.Context, key, mtype string, payload any) error {
+	return withPGRetry(ctx, func() error {
+		db, err := p.conn(ctx)
+		if err != nil {
 			return err
 		}
 
-		p.mu.Lock()
-		defer p.mu.Unlock()
+		name, labels := splitSeriesKey(key)
+		return execPayloadUpsert(ctx, db, name, mtype, labels, payload)
+	})
+}
 
-		tx, err := p.db.BeginTx(ctx, nil)
-		if err != nil {
-			return fmt.Errorf("begin transaction: %w", err)
+func (p *PostgresStore) getPayload(ctx context.Context, key, mtype string, payload any) error {
+	db, err := p.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	name, labels := splitSeriesKey(key)
+	query := "SELECT payload FROM metrics WHERE name = $1 AND mtype = $2 AND labels = $3"
+	err = db.QueryRowContext(ctx, query, name, mtype, labels).Scan(&data)
+	if err == sql.ErrNoRows {
+		return customerrors.ErrKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, payload)
+}
+
+func (p *PostgresStore) getPayloads(ctx context.Context, mtype string, decode func(key string, data []byte) error) error {
+	db, err := p.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT name, labels, payload FROM metrics WHERE mtype = $1", mtype)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name   string
+			labels string
+			data   []byte
+		)
+		if err := rows.Scan(&name, &labels, &data); err != nil {
+			return err
 		}
-		defer func() { _ = tx.Rollback() }()
-
-		for _, m := range metrics {
-			switch m.MType {
-			case model.GaugeType:
-				_, err = tx.ExecContext(ctx, `
-					INSERT INTO metrics (name, mtype, value)
-					VALUES ($1, $2, $3) 
-					ON CONFLICT (name, mtype) DO UPDATE SET value = $3
-				`, m.ID, m.MType, m.Value)
-			case model.CounterType:
-				_, err = tx.ExecContext(ctx, `
-					INSERT INTO metrics (name, mtype, delta)
-					VALUES ($1, $2, $3) 
-					ON CONFLICT (name, mtype) DO UPDATE SET delta = metrics.delta + $3
-				`, m.ID, m.MType, m.Delta)
-			default:
-				return fmt.Errorf("unsupported metric type: %s", m.MType)
-			}
-			if err != nil {
-				log.Printf("UpdateMetricsBatch SQL error for ID=%s: %v", m.ID, err)
-				return fmt.Errorf("exec update for %s: %w", m.ID, err)
-			}
+		if labels != "" {
+			name += "{" + labels + "}"
+		}
+		if err := decode(name, data); err != nil {
+			return err
 		}
+	}
+	return rows.Err()
+}
+
+func (p *PostgresStore) SetHistogram(ctx context.Context, name string, value *model.Histogram) error {
+	return p.setPayload(ctx, name, model.HistogramType, value)
+}
 
-		if err := tx.Commit(); err != nil {
-			return fmt.Errorf("commit transaction: %w", err)
+func (p *PostgresStore) GetHistogram(ctx context.Context, name string) (*model.Histogram, error) {
+	var value model.Histogram
+	if err := p.getPayload(ctx, name, model.HistogramType, &value); err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+func (p *PostgresStore) GetHistograms(ctx context.Context) (map[string]*model.Histogram, error) {
+	result := make(map[string]*model.Histogram)
+	err := p.getPayloads(ctx, model.HistogramType, func(key string, data []byte) error {
+		var value model.Histogram
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
 		}
+		result[key] = &value
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (p *PostgresStore) SetSummary(ctx context.Context, name string, value *model.Summary) error {
+	return p.setPayload(ctx, name, model.SummaryType, value)
+}
+
+func (p *PostgresStore) GetSummary(ctx context.Context, name string) (*model.Summary, error) {
+	var value model.Summary
+	if err := p.getPayload(ctx, name, model.SummaryType, &value); err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+func (p *PostgresStore) GetSummaries(ctx context.Context) (map[string]*model.Summary, error) {
+	result := make(map[string]*model.Summary)
+	err := p.getPayloads(ctx, model.SummaryType, func(key string, data []byte) error {
+		var value model.Summary
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		result[key] = &value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 func (p *PostgresStore) GetGauge(ctx context.Context, name string) (float64, error) {
-	if err := p.ensureConnected(ctx); err != nil {
+	db, err := p.conn(ctx)
+	if err != nil {
 		return 0, err
 	}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
 	var value float64
-	query := "SELECT value FROM metrics WHERE name = $1 AND mtype = 'gauge'"
-	err := p.db.QueryRowContext(ctx, query, name).Scan(&value)
+	metricName, labels := splitSeriesKey(name)
+	query := "SELECT value FROM metrics WHERE name = $1 AND mtype = 'gauge' AND labels = $2"
+	err = db.QueryRowContext(ctx, query, metricName, labels).Scan(&value)
 	if err == sql.ErrNoRows {
 		return 0, customerrors.ErrKeyNotFound
 	}
@@ -211,16 +323,15 @@ func (p *PostgresStore) GetGauge(ctx context.Context, name string) (float64, err
 }
 
 func (p *PostgresStore) GetCounter(ctx context.Context, name string) (int64, error) {
-	if err := p.ensureConnected(ctx); err != nil {
+	db, err := p.conn(ctx)
+	if err != nil {
 		return 0, err
 	}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
 	var delta int64
-	query := "SELECT delta FROM metrics WHERE name = $1 AND mtype = 'counter'"
-	err := p.db.QueryRowContext(ctx, query, name).Scan(&delta)
+	metricName, labels := splitSeriesKey(name)
+	query := "SELECT delta FROM metrics WHERE name = $1 AND mtype = 'counter' AND labels = $2"
+	err = db.QueryRowContext(ctx, query, metricName, labels).Scan(&delta)
 	if err == sql.ErrNoRows {
 		return 0, customerrors.ErrKeyNotFound
 	}
@@ -228,17 +339,15 @@ func (p *PostgresStore) GetCounter(ctx context.Context, name string) (int64, err
 }
 
 func (p *PostgresStore) GetAll(ctx context.Context) (map[string]float64, map[string]int64, error) {
-	if err := p.ensureConnected(ctx); err != nil {
+	db, err := p.conn(ctx)
+	if err != nil {
 		return nil, nil, err
 	}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
 	gauges := make(map[string]float64)
 	counters := make(map[string]int64)
 
-	rows, err := p.db.QueryContext(ctx, "SELECT name, mtype, value, delta FROM metrics")
+	rows, err := db.QueryContext(ctx, "SELECT name, mtype, labels, value, delta FROM metrics")
 	if err != nil {
 		return nil, nil, err
 	}
@@ -246,14 +355,18 @@ func (p *PostgresStore) GetAll(ctx context.Context) (map[string]float64, map[str
 
 	for rows.Next() {
 		var (
-			name  string
-			mtype string
-			value sql.NullFloat64
-			delta sql.NullInt64
+			name   string
+			mtype  string
+			labels string
+			value  sql.NullFloat64
+			delta  sql.NullInt64
 		)
-		if err := rows.Scan(&name, &mtype, &value, &delta); err != nil {
+		if err := rows.Scan(&name, &mtype, &labels, &value, &delta); err != nil {
 			return nil, nil, err
 		}
+		if labels != "" {
+			name += "{" + labels + "}"
+		}
 
 		switch mtype {
 		case model.GaugeType:
@@ -274,29 +387,96 @@ func (p *PostgresStore) GetAll(ctx context.Context) (map[string]float64, map[str
 	return gauges, counters, nil
 }
 
-func (p *PostgresStore) Ping(ctx context.Context) error {
-	if err := p.ensureConnected(ctx); err != nil {
-		return customerrors.ErrNotConnected
+func (p *PostgresStore) QueryRange(ctx context.Context, name, mtype string, from, to time.Time, step time.Duration) ([]*model.Series, error) {
+	db, err := p.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT labels, ts, value FROM metric_history
+		WHERE name = $1 AND mtype = $2 AND ts >= $3 AND ts <= $4
+		ORDER BY labels, ts
+	`, name, mtype, from, to)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	points := make(map[string][]model.Point)
+	for rows.Next() {
+		var (
+			labels string
+			point  model.Point
+		)
+		if err := rows.Scan(&labels, &point.Timestamp, &point.Value); err != nil {
+			return nil, err
+		}
+		points[labels] = append(points[labels], point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	if !p.connected {
-		return customerrors.ErrNotConnected
+	result := make([]*model.Series, 0, len(points))
+	for rawLabels, series := range points {
+		labels, err := model.ParseLabels(rawLabels)
+		if err != nil {
+			return nil, err
+		}
+		downsampled := downsample(series, from, to, step)
+		if len(downsampled) == 0 {
+			continue
+		}
+		result = append(result, &model.Series{
+			ID:     name,
+			MType:  mtype,
+			Labels: labels,
+			Points: downsampled,
+		})
 	}
-	return p.db.PingContext(ctx)
+
+	sortSeries(result)
+	return result, nil
 }
 
-func (p *PostgresStore) Close() error {
-	if !p.connected {
-		return nil
+// PruneHistory deletes metric_history rows older than the retention and
+// returns how many were removed.
+func (p *PostgresStore) PruneHistory(ctx context.Context) (int64, error) {
+	if p.retention <= 0 {
+		return 0, nil
 	}
+	db, err := p.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := db.ExecContext(ctx,
+		`DELETE FROM metric_history WHERE ts < now() - make_interval(secs => $1)`,
+		p.retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
 
+func (p *PostgresStore) Ping(ctx context.Context) error {
+	db, err := p.conn(ctx)
+	if err != nil {
+		return customerrors.ErrNotConnected
+	}
+	return db.PingContext(ctx)
+}
+
+func (p *PostgresStore) Close() error {
 	var err error
 	p.closeOnce.Do(func() {
-		err = p.db.Close()
-		p.connected = false
+		p.connectMu.Lock()
+		defer p.connectMu.Unlock()
+		p.closed.Store(true)
+		if db := p.db.Swap(nil); db != nil {
+			err = db.Close()
+		}
 	})
 	return err
 }
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/Heidric/metrics.git/internal/model"
+)
+
+// bulkColumns holds one metric type of a batch in columnar form, ready to be
+// passed as arrays to unnest.
+type bulkColumns struct {
+	names    []string
+	labels   []string
+	values   []float64
+	deltas   []int64
+	payloads []string
+	index    map[string]int
+}
+
+func (c *bulkColumns) slot(name, labels string) (int, bool) {
+	if c.index == nil {
+		c.index = make(map[string]int)
+	}
+	key := name + "\x00" + labels
+	if i, ok := c.index[key]; ok {
+		return i, true
+	}
+	c.index[key] = len(c.names)
+	c.names = append(c.names, name)
+	c.labels = append(c.labels, labels)
+	return len(c.names) - 1, false
+}
+
+type bulkBatch struct {
+	gauges     bulkColumns
+	counters   bulkColumns
+	histograms bulkColumns
+	summaries  bulkColumns
+}
+
+func (b *bulkBatch) empty() bool {
+	return len(b.gauges.names)+len(b.counters.names)+len(b.histograms.names)+len(b.summaries.names) == 0
+}
+
+// newBulkBatch collapses duplicate series: counter deltas are summed, while
+// gauges and payload types keep the last value, as sequential upserts would.
+// A multi-row upsert cannot touch the same row twice, so this is required.
+// Metrics without a value are rejected rather than dropped from the batch.
+func newBulkBatch(metrics []*model.Metrics) (*bulkBatch, error) {
+	b := &bulkBatch{}
+	for _, m := range metrics {
+		labels := m.Labels.String()
+		switch m.MType {
+		case model.GaugeType:
+			if m.Value == nil {
+				return nil, fmt.Errorf("gauge %s: %w", m.ID, customerrors.ErrInvalidValue)
+			}
+			i, ok := b.gauges.slot(m.ID, labels)
+			if ok {
+				b.gauges.values[i] = *m.Value
+			} else {
+				b.gauges.values = append(b.gauges.values, *m.Value)
+			}
+		case model.CounterType:
+			if m.Delta == nil {
+				return nil, fmt.Errorf("counter %s: %w", m.ID, customerrors.ErrInvalidValue)
+			}
+			i, ok := b.counters.slot(m.ID, labels)
+			if ok {
+				b.counters.deltas[i] += *m.Delta
+			} else {
+				b.counters.deltas = append(b.counters.deltas, *m.Delta)
+			}
+		case model.HistogramType:
+			if m.Histogram == nil {
+				return nil, fmt.Errorf("histogram %s: %w", m.ID, customerrors.ErrInvalidValue)
+			}
+			if err := b.histograms.addPayload(m.ID, labels, m.Histogram); err != nil {
+				return nil, err
+			}
+		case model.SummaryType:
+			if m.Summary == nil {
+				return nil, fmt.Errorf("summary %s: %w", m.ID, customerrors.ErrInvalidValue)
+			}
+			if err := b.summaries.addPayload(m.ID, labels, m.Summary); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported metric type: %s", m.MType)
+		}
+	}
+	return b, nil
+}
+
+func (c *bulkColumns) addPayload(name, labels string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal payload for %s: %w", name, err)
+	}
+	i, ok := c.slot(name, labels)
+	if ok {
+		c.payloads[i] = string(data)
+	} else {
+		c.payloads = append(c.payloads, string(data))
+	}
+	return nil
+}
+
+const (
+	bulkGaugeQuery = `
+		WITH upserted AS (
+			INSERT INTO metrics (name, mtype, labels, value)
+			SELECT name, 'gauge', labels, value
+			FROM unnest($1::text[], $2::text[], $3::double precision[]) AS input(name, labels, value)
+			ON CONFLICT (name, mtype, labels) DO UPDATE SET value = EXCLUDED.value
+			RETURNING name, mtype, labels, value
+		)
+		INSERT INTO metric_history (name, mtype, labels, value)
+		SELECT name, mtype, labels, value FROM upserted
+	`
+	bulkCounterQuery = `
+		WITH upserted AS (
+			INSERT INTO metrics (name, mtype, labels, delta)
+			SELECT name, 'counter', labels, delta
+			FROM unnest($1::text[], $2::text[], $3::bigint[]) AS input(name, labels, delta)
+			ON CONFLICT (name, mtype, labels) DO UPDATE SET delta = metrics.delta + EXCLUDED.delta
+			RETURNING name, mtype, labels, delta
+		)
+		INSERT INTO metric_history (name, mtype, labels, value)
+		SELECT name, mtype, labels, delta FROM upserted
+	`
+	bulkPayloadQuery = `
+		INSERT INTO metrics (name, mtype, labels, payload)
+		SELECT name, $1, labels, payload::jsonb
+		FROM unnest($2::text[], $3::text[], $4::text[]) AS input(name, labels, payload)
+		ON CONFLICT (name, mtype, labels) DO UPDATE SET payload = EXCLUDED.payload
+	`
+)
+
+// UpdateMetricsBatch writes the whole batch with at most one statement per
+// metric type, whatever its size.
+func (p *PostgresStore) UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error {
+	batch, err := newBulkBatch(metrics)
+	if err != nil {
+		return err
+	}
+	if batch.empty() {
+		return nil
+	}
+
+	return withPGRetry(ctx, func() error {
+		db, err := p.conn(ctx)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("begin transaction: %w", err)
+		}
+		defer func() { _ = tx.Rollback() }()
+
+		if len(batch.gauges.names) > 0 {
+			if _, err := tx.ExecContext(ctx, bulkGaugeQuery, batch.gauges.names, batch.gauges.labels, batch.gauges.values); err != nil {
+				return fmt.Errorf("bulk upsert gauges: %w", err)
+			}
+		}
+		if len(batch.counters.names) > 0 {
+			if _, err := tx.ExecContext(ctx, bulkCounterQuery, batch.counters.names, batch.counters.labels, batch.counters.deltas); err != nil {
+				return fmt.Errorf("bulk upsert counters: %w", err)
+			}
+		}
+		for _, payloads := range []struct {
+			mtype   string
+			columns *bulkColumns
+		}{
+			{model.HistogramType, &batch.histograms},
+			{model.SummaryType, &batch.summaries},
+		} {
+			if len(payloads.columns.names) == 0 {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, bulkPayloadQuery,
+				payloads.mtype, payloads.columns.names, payloads.columns.labels, payloads.columns.payloads); err != nil {
+				return fmt.Errorf("bulk upsert %s: %w", payloads.mtype, err)
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit transaction: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// arrayConverter lets sqlmock accept the slice arguments pgx binds as arrays.
+type arrayConverter struct{}
+
+func (arrayConverter) ConvertValue(v any) (driver.Value, error) {
+	switch v.(type) {
+	case []string, []float64, []int64:
+		return v, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func TestNewBulkBatch(t *testing.T) {
+	gauge := func(v float64) *float64 { return &v }
+	delta := func(d int64) *int64 { return &d }
+
+	batch, err := newBulkBatch([]*model.Metrics{
+		{ID: "requests", MType: model.CounterType, Delta: delta(2)},
+		{ID: "cpu", MType: model.GaugeType, Value: gauge(1)},
+		{ID: "requests", MType: model.CounterType, Delta: delta(3)},
+		{ID: "requests", MType: model.CounterType, Delta: delta(1), Labels: model.Labels{"host": "a"}},
+		{ID: "cpu", MType: model.GaugeType, Value: gauge(2)},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"cpu"}, batch.gauges.names)
+	assert.Equal(t, []float64{2}, batch.gauges.values, "last gauge value wins")
+	assert.Equal(t, []string{"requests", "requests"}, batch.counters.names)
+	assert.Equal(t, []string{"", `host="a"`}, batch.counters.labels)
+	assert.Equal(t, []int64{5, 1}, batch.counters.deltas, "counter deltas are summed per series")
+
+	_, err = newBulkBatch([]*model.Metrics{{ID: "x", MType: "unknown"}})
+	assert.Error(t, err)
+
+	for _, m := range []*model.Metrics{
+		{ID: "cpu", MType: model.GaugeType},
+		{ID: "requests", MType: model.CounterType},
+		{ID: "latency", MType: model.HistogramType},
+		{ID: "rpc", MType: model.SummaryType},
+	} {
+		_, err = newBulkBatch([]*model.Metrics{m})
+		assert.ErrorIs(t, err, customerrors.ErrInvalidValue, m.MType)
+	}
+}
+
+func TestPostgresStore_UpdateMetricsBatch(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := newMockedPostgresStore(db)
+
+	value, delta := 1.5, int64(3)
+	histogram := &model.Histogram{Buckets: []model.Bucket{{UpperBound: 1, Count: 1}}, Sum: 0.5, Count: 1}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(bulkGaugeQuery)).
+		WithArgs([]string{"cpu"}, []string{`host="a"`}, []float64{value}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(bulkCounterQuery)).
+		WithArgs([]string{"requests"}, []string{""}, []int64{2 * delta}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(bulkPayloadQuery)).
+		WithArgs(model.HistogramType, []string{"latency"}, []string{""}, []string{`{"buckets":[{"le":1,"count":1}],"sum":0.5,"count":1}`}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = store.UpdateMetricsBatch(context.Background(), []*model.Metrics{
+		{ID: "cpu", MType: model.GaugeType, Value: &value, Labels: model.Labels{"host": "a"}},
+		{ID: "requests", MType: model.CounterType, Delta: &delta},
+		{ID: "requests", MType: model.CounterType, Delta: &delta},
+		{ID: "latency", MType: model.HistogramType, Histogram: histogram},
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	require.NoError(t, store.UpdateMetricsBatch(context.Background(), nil), "empty batch skips the transaction")
+}
+
+func benchmarkMetrics(n int) []*model.Metrics {
+	metrics := make([]*model.Metrics, 0, n)
+	for i := 0; i < n; i++ {
+		v, d := float64(i), int64(i)
+		metrics = append(metrics,
+			&model.Metrics{ID: fmt.Sprintf("gauge_%d", i%(n/2+1)), MType: model.GaugeType, Value: &v},
+			&model.Metrics{ID: fmt.Sprintf("counter_%d", i%(n/4+1)), MType: model.CounterType, Delta: &d},
+		)
+	}
+	return metrics[:n]
+}
+
+// BenchmarkUpdateMetricsBatch compares the per-row and bulk paths. Set
+// BENCH_DATABASE_DSN to run against a real Postgres; otherwise sqlmock measures
+// the client-side cost and the number of round trips.
+func BenchmarkUpdateMetricsBatch(b *testing.B) {
+	paths := []struct {
+		name string
+		fn   func(*PostgresStore, context.Context, []*model.Metrics) error
+	}{
+		{"per-row", (*PostgresStore).updateMetricsBatchPerRow},
+		{"bulk", (*PostgresStore).UpdateMetricsBatch},
+	}
+	dsn := os.Getenv("BENCH_DATABASE_DSN")
+
+	for _, size := range []int{10, 100, 1000} {
+		metrics := benchmarkMetrics(size)
+		for _, path := range paths {
+			b.Run(fmt.Sprintf("%s/%d", path.name, size), func(b *testing.B) {
+				ctx := context.Background()
+
+				if dsn != "" {
+					store := NewPostgresStore(dsn)
+					defer store.Close()
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						if err := path.fn(store, ctx, metrics); err != nil {
+							b.Fatal(err)
+						}
+					}
+					return
+				}
+
+				statements := size
+				if path.name == "bulk" {
+					statements = 2
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					// A fresh mock per iteration keeps expectation lookups from growing with b.N.
+					b.StopTimer()
+					db, mock, err := sqlmock.New(
+						sqlmock.ValueConverterOption(arrayConverter{}),
+						sqlmock.QueryMatcherOption(sqlmock.QueryMatcherFunc(func(string, string) error { return nil })),
+					)
+					if err != nil {
+						b.Fatal(err)
+					}
+					store := newMockedPostgresStore(db)
+					mock.ExpectBegin()
+					for j := 0; j < statements; j++ {
+						mock.ExpectExec("").WillReturnResult(sqlmock.NewResult(0, 1))
+					}
+					mock.ExpectCommit()
+					b.StartTimer()
+
+					if err := path.fn(store, ctx, metrics); err != nil {
+						b.Fatal(err)
+					}
+
+					b.StopTimer()
+					db.Close()
+					b.StartTimer()
+				}
+			})
+		}
+	}
+}
+
+// updateMetricsBatchPerRow is the original one-statement-per-metric path. It is
+// kept as the baseline for BenchmarkUpdateMetricsBatch.
+func (p *PostgresStore) updateMetricsBatchPerRow(ctx context.Context, metrics []*model.Metrics) error {
+	return withPGRetry(ctx, func() error {
+		db, err := p.conn(ctx)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("begin transaction: %w", err)
+		}
+		defer func() { _ = tx.Rollback() }()
+
+		for _, m := range metrics {
+			switch m.MType {
+			case model.GaugeType:
+				_, err = tx.ExecContext(ctx, `
+					WITH upserted AS (
+						INSERT INTO metrics (name, mtype, labels, value)
+						VALUES ($1, $2, $3, $4)
+						ON CONFLICT (name, mtype, labels) DO UPDATE SET value = $4
+						RETURNING name, mtype, labels, value
+					)
+					INSERT INTO metric_history (name, mtype, labels, value)
+					SELECT name, mtype, labels, value FROM upserted
+				`, m.ID, m.MType, m.Labels.String(), m.Value)
+			case model.CounterType:
+				_, err = tx.ExecContext(ctx, `
+					WITH upserted AS (
+						INSERT INTO metrics (name, mtype, labels, delta)
+						VALUES ($1, $2, $3, $4)
+						ON CONFLICT (name, mtype, labels) DO UPDATE SET delta = metrics.delta + $4
+						RETURNING name, mtype, labels, delta
+					)
+					INSERT INTO metric_history (name, mtype, labels, value)
+					SELECT name, mtype, labels, delta FROM upserted
+				`, m.ID, m.MType, m.Labels.String(), m.Delta)
+			case model.HistogramType:
+				err = execPayloadUpsert(ctx, tx, m.ID, m.MType, m.Labels.String(), m.Histogram)
+			case model.SummaryType:
+				err = execPayloadUpsert(ctx, tx, m.ID, m.MType, m.Labels.String(), m.Summary)
+			default:
+				return fmt.Errorf("unsupported metric type: %s", m.MType)
+			}
+			if err != nil {
+				return fmt.Errorf("exec update for %s: %w", m.ID, err)
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit transaction: %w", err)
+		}
+		return nil
+	})
+}
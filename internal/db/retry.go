@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -10,7 +11,8 @@ import (
 
 var retryDelays = []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
 
-func withRetry(fn func() error, isRetriable func(error) bool) error {
+// withRetry stops waiting as soon as ctx is done and returns the last error.
+func withRetry(ctx context.Context, fn func() error, isRetriable func(error) bool) error {
 	var err error
 	for attempt := 0; attempt <= len(retryDelays); attempt++ {
 		err = fn()
@@ -21,14 +23,20 @@ func withRetry(fn func() error, isRetriable func(error) bool) error {
 			return err
 		}
 		if attempt < len(retryDelays) {
-			time.Sleep(retryDelays[attempt])
+			timer := time.NewTimer(retryDelays[attempt])
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return errors.Join(err, ctx.Err())
+			}
 		}
 	}
 	return err
 }
 
-func withPGRetry(fn func() error) error {
-	return withRetry(fn, isRetriable)
+func withPGRetry(ctx context.Context, fn func() error) error {
+	return withRetry(ctx, fn, isRetriable)
 }
 
 func isRetriable(err error) bool {
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -80,7 +81,7 @@ func TestWithRetry(t *testing.T) {
 				attempts++
 				return test.exec()
 			}
-			err := withRetry(exec, func(err error) bool { return err.Error() != "permanent failure" })
+			err := withRetry(context.Background(), exec, func(err error) bool { return err.Error() != "permanent failure" })
 			if (err == nil) != (test.expectedErr == nil) {
 				t.Errorf("unexpected error state: got %v, want %v", err, test.expectedErr)
 			}
@@ -90,3 +91,25 @@ func TestWithRetry(t *testing.T) {
 		})
 	}
 }
+
+func TestWithRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	exec := func() error {
+		attempts++
+		cancel()
+		return errors.New("temporary failure")
+	}
+
+	start := time.Now()
+	err := withRetry(ctx, exec, func(error) bool { return true })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected a single attempt, got %d", attempts)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("retry sleep was not interrupted, took %v", elapsed)
+	}
+}
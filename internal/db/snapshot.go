@@ -0,0 +1,113 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/model"
+)
+
+// SnapshotVersion is the format written by saveToFile. Bump it together with a
+// new entry in snapshotMigrations whenever the snapshot layout changes.
+const SnapshotVersion = 2
+
+type snapshot struct {
+	Gauges     map[string]float64          `json:"gauges"`
+	Counters   map[string]int64            `json:"counters"`
+	Histograms map[string]*model.Histogram `json:"histograms,omitempty"`
+	Summaries  map[string]*model.Summary   `json:"summaries,omitempty"`
+	Seq        uint64                      `json:"seq,omitempty"`
+}
+
+type snapshotEnvelope struct {
+	Version int             `json:"version"`
+	SavedAt time.Time       `json:"saved_at"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// snapshotMigrations[i] upgrades a payload from version i+1 to version i+2.
+var snapshotMigrations = []func(json.RawMessage) (json.RawMessage, error){
+	// Version 1 is the bare, unversioned document written before the envelope
+	// existed. Its payload layout is unchanged.
+	func(data json.RawMessage) (json.RawMessage, error) { return data, nil },
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func encodeSnapshot(w io.Writer, data snapshot, compress bool) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	envelope := snapshotEnvelope{
+		Version: SnapshotVersion,
+		SavedAt: time.Now().UTC(),
+		Data:    payload,
+	}
+
+	if !compress {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(envelope)
+	}
+
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(envelope); err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// decodeSnapshot reads any snapshot version, plain or gzip-compressed, and
+// upgrades it to the current layout.
+func decodeSnapshot(r io.Reader) (snapshot, error) {
+	var data snapshot
+
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return data, fmt.Errorf("failed to open gzip snapshot: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return data, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var envelope snapshotEnvelope
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return data, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if envelope.Version == 0 {
+		envelope.Version = 1
+		envelope.Data = raw
+	}
+	if envelope.Version > SnapshotVersion {
+		return data, fmt.Errorf("snapshot version %d is newer than supported version %d", envelope.Version, SnapshotVersion)
+	}
+
+	payload := envelope.Data
+	for v := envelope.Version; v < SnapshotVersion; v++ {
+		payload, err = snapshotMigrations[v-1](payload)
+		if err != nil {
+			return data, fmt.Errorf("failed to migrate snapshot from version %d: %w", v, err)
+		}
+	}
+
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return data, fmt.Errorf("failed to decode snapshot data: %w", err)
+	}
+	return data, nil
+}
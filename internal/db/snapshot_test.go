@@ -0,0 +1,71 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotFormat(t *testing.T) {
+	data := snapshot{
+		Gauges:     map[string]float64{"Alloc": 1.5},
+		Counters:   map[string]int64{"PollCount": 3},
+		Histograms: map[string]*model.Histogram{"latency": {Buckets: []model.Bucket{{UpperBound: 1, Count: 2}}, Sum: 1, Count: 2}},
+		Seq:        7,
+	}
+
+	t.Run("Round trip", func(t *testing.T) {
+		for _, compress := range []bool{false, true} {
+			var buf bytes.Buffer
+			require.NoError(t, encodeSnapshot(&buf, data, compress))
+			assert.Equal(t, compress, bytes.HasPrefix(buf.Bytes(), gzipMagic))
+
+			got, err := decodeSnapshot(&buf)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+		}
+	})
+
+	t.Run("Legacy unversioned document", func(t *testing.T) {
+		got, err := decodeSnapshot(strings.NewReader(`{"gauges":{"Alloc":1.5},"counters":{"PollCount":3}}`))
+		require.NoError(t, err)
+		assert.Equal(t, 1.5, got.Gauges["Alloc"])
+		assert.Equal(t, int64(3), got.Counters["PollCount"])
+	})
+
+	t.Run("Newer version", func(t *testing.T) {
+		_, err := decodeSnapshot(strings.NewReader(`{"version":99,"data":{}}`))
+		assert.ErrorContains(t, err, "newer than supported")
+	})
+
+	t.Run("Store switches to compressed snapshots", func(t *testing.T) {
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "metrics.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"gauges":{"Alloc":1.5},"counters":{}}`), 0o644))
+
+		store := NewStore(path, 0)
+		store.SetCompression(true)
+		require.NoError(t, store.SetCounter(ctx, "PollCount", 2))
+		require.NoError(t, store.Close())
+
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(raw, gzipMagic))
+
+		restored := NewStore(path, 0)
+		defer restored.Close()
+		gauge, err := restored.GetGauge(ctx, "Alloc")
+		require.NoError(t, err)
+		assert.Equal(t, 1.5, gauge)
+		counter, err := restored.GetCounter(ctx, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), counter)
+	})
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Heidric/metrics.git/internal/model"
+)
+
+// walOp is a single mutation. Counters store the delta, not the running total,
+// so replaying an op on top of a snapshot that predates it is exact.
+type walOp struct {
+	Type      string           `json:"type"`
+	Key       string           `json:"key"`
+	Value     *float64         `json:"value,omitempty"`
+	Delta     *int64           `json:"delta,omitempty"`
+	Histogram *model.Histogram `json:"histogram,omitempty"`
+	Summary   *model.Summary   `json:"summary,omitempty"`
+}
+
+// walRecord groups the ops of one update call. Records are numbered so that a
+// snapshot can tell which of them it already contains.
+type walRecord struct {
+	Seq uint64  `json:"seq"`
+	Ops []walOp `json:"ops"`
+}
+
+func (s *Store) walPath() string {
+	return s.filePath + ".wal"
+}
+
+// appendWAL must be called with s.mu held for writing.
+func (s *Store) appendWAL(ops ...walOp) error {
+	if !s.walEnabled || len(ops) == 0 {
+		return nil
+	}
+
+	if s.wal == nil {
+		file, err := os.OpenFile(s.walPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open WAL: %w", err)
+		}
+		s.wal = file
+	}
+
+	record := walRecord{Seq: s.walSeq + 1, Ops: ops}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode WAL record: %w", err)
+	}
+	data = append(data, '\n')
+
+	if _, err := s.wal.Write(data); err != nil {
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+	if err := s.wal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL: %w", err)
+	}
+	s.walSeq = record.Seq
+	return nil
+}
+
+// readWAL returns the records with a sequence number above after. A torn
+// record at the tail, left by a crash mid-append, ends the log.
+func readWAL(path string, after uint64) ([]walRecord, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL: %w", err)
+	}
+	defer file.Close()
+
+	var records []walRecord
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read WAL: %w", err)
+		}
+
+		var record walRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return records, nil
+		}
+		if record.Seq > after {
+			records = append(records, record)
+		}
+	}
+}
+
+// apply must be called with s.mu held for writing.
+func (s *Store) apply(op walOp) {
+	switch op.Type {
+	case model.GaugeType:
+		if op.Value != nil {
+			s.gauges[op.Key] = *op.Value
+		}
+	case model.CounterType:
+		if op.Delta != nil {
+			s.counters[op.Key] += *op.Delta
+		}
+	case model.HistogramType:
+		if op.Histogram != nil {
+			s.histograms[op.Key] = op.Histogram
+		}
+	case model.SummaryType:
+		if op.Summary != nil {
+			s.summaries[op.Key] = op.Summary
+		}
+	}
+}
+
+// compactWAL drops the records already covered by a snapshot taken at seq.
+func (s *Store) compactWAL(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wal != nil {
+		if err := s.wal.Close(); err != nil {
+			return fmt.Errorf("failed to close WAL: %w", err)
+		}
+		s.wal = nil
+	}
+
+	records, err := readWAL(s.walPath(), seq)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		if err := os.Remove(s.walPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove WAL: %w", err)
+		}
+		return nil
+	}
+
+	return writeFileAtomic(s.walPath(), func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeFileAtomic writes to a temporary file in the same directory, syncs it
+// and renames it over path, so readers see either the old or the new content.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	maxBatchSize = 500
+	// maxLineSize bounds how much of a connection is buffered while waiting
+	// for a newline.
+	maxLineSize = 64 * 1024
+)
+
+var errInvalidLine = errors.New("invalid graphite line")
+
+type Sink interface {
+	UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error
+}
+
+type Config struct {
+	Address     string
+	MaxConns    int
+	IdleTimeout time.Duration
+}
+
+// parseLine parses "path value timestamp". Tagged paths use the
+// name;tag=value;... form and the tags become labels. The timestamp is
+// validated but the value is stored as of now, like every other write.
+func parseLine(line string) (*model.Metrics, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return nil, errInvalidLine
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, errInvalidLine
+	}
+	if _, err := strconv.ParseFloat(fields[2], 64); err != nil {
+		return nil, errInvalidLine
+	}
+
+	parts := strings.Split(fields[0], ";")
+	if parts[0] == "" {
+		return nil, errInvalidLine
+	}
+	m := &model.Metrics{ID: parts[0], MType: model.GaugeType, Value: &value}
+	for _, tag := range parts[1:] {
+		k, v, ok := strings.Cut(tag, "=")
+		if !ok || k == "" || v == "" {
+			return nil, errInvalidLine
+		}
+		if m.Labels == nil {
+			m.Labels = make(model.Labels)
+		}
+		m.Labels[k] = v
+	}
+	if err := m.Labels.Validate(); err != nil {
+		return nil, errInvalidLine
+	}
+	return m, nil
+}
+
+// Listener accepts Graphite plaintext protocol connections over TCP.
+type Listener struct {
+	cfg    Config
+	sink   Sink
+	logger *zerolog.Logger
+
+	listener net.Listener
+	slots    chan struct{}
+	conns    sync.WaitGroup
+}
+
+func NewListener(cfg Config, sink Sink, logger *zerolog.Logger) *Listener {
+	if logger == nil {
+		nop := zerolog.Nop()
+		logger = &nop
+	}
+	if cfg.MaxConns <= 0 {
+		cfg.MaxConns = 100
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 5 * time.Minute
+	}
+
+	return &Listener{
+		cfg:    cfg,
+		sink:   sink,
+		logger: logger,
+		slots:  make(chan struct{}, cfg.MaxConns),
+	}
+}
+
+func (l *Listener) Run(ctx context.Context, runner *errgroup.Group) error {
+	listener, err := net.Listen("tcp", l.cfg.Address)
+	if err != nil {
+		return err
+	}
+	l.listener = listener
+
+	l.logger.Info().Str("address", listener.Addr().String()).Msg("Graphite listener started")
+
+	runner.Go(func() error {
+		<-ctx.Done()
+		return listener.Close()
+	})
+	runner.Go(func() error {
+		err := l.serve(ctx)
+		l.conns.Wait()
+		return err
+	})
+	return nil
+}
+
+func (l *Listener) serve(ctx context.Context) error {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			l.logger.Error().Err(err).Msg("Failed to accept Graphite connection")
+			continue
+		}
+
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.logger.Warn().Str("remote", conn.RemoteAddr().String()).Msg("Graphite connection limit reached, connection refused")
+			conn.Close()
+			continue
+		}
+
+		l.conns.Add(1)
+		go func() {
+			defer l.conns.Done()
+			defer func() { <-l.slots }()
+			l.serveConn(ctx, conn)
+		}()
+	}
+}
+
+// serveConn batches lines and flushes whenever the batch is full or the
+// connection has no more buffered input, so a burst becomes a single write.
+// A line longer than maxLineSize closes the connection.
+func (l *Listener) serveConn(ctx context.Context, conn net.Conn) {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	reader := bufio.NewReaderSize(conn, maxLineSize)
+	var batch []*model.Metrics
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := l.sink.UpdateMetricsBatch(context.WithoutCancel(ctx), batch); err != nil {
+			l.logger.Error().Err(err).Int("metrics", len(batch)).Msg("Failed to store Graphite metrics")
+		}
+		batch = nil
+	}
+	defer flush()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(l.cfg.IdleTimeout))
+		raw, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			l.logger.Warn().Str("remote", conn.RemoteAddr().String()).Msg("Graphite line too long, connection closed")
+			return
+		}
+		if line := strings.TrimSpace(string(raw)); line != "" {
+			if m, parseErr := parseLine(line); parseErr != nil {
+				l.logger.Debug().Str("line", line).Msg("Invalid Graphite line dropped")
+			} else {
+				batch = append(batch, m)
+			}
+		}
+		if err != nil {
+			return
+		}
+		if len(batch) >= maxBatchSize || reader.Buffered() == 0 {
+			flush()
+		}
+	}
+}
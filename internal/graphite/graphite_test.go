@@ -0,0 +1,189 @@
+package graphite
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	metrics []*model.Metrics
+	batches int
+}
+
+func (r *recordingSink) UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, metrics...)
+	r.batches++
+	return nil
+}
+
+func (r *recordingSink) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.metrics)
+}
+
+func TestParseLine(t *testing.T) {
+	m, err := parseLine("servers.web1.load 1.5 1700000000")
+	require.NoError(t, err)
+	assert.Equal(t, "servers.web1.load", m.ID)
+	assert.Equal(t, model.GaugeType, m.MType)
+	assert.Equal(t, 1.5, *m.Value)
+	assert.Nil(t, m.Labels)
+
+	m, err = parseLine("disk.used;host=web1;mount=/var 42 -1")
+	require.NoError(t, err)
+	assert.Equal(t, "disk.used", m.ID)
+	assert.Equal(t, model.Labels{"host": "web1", "mount": "/var"}, m.Labels)
+
+	for _, line := range []string{
+		"load 1.5",
+		"load 1.5 1700000000 extra",
+		"load abc 1700000000",
+		"load NaN 1700000000",
+		"load 1 yesterday",
+		";host=a 1 1700000000",
+		"load;host 1 1700000000",
+		"load;bad-tag=a 1 1700000000",
+	} {
+		_, err := parseLine(line)
+		assert.Error(t, err, line)
+	}
+}
+
+type blockingSink struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSink) UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error {
+	close(b.entered)
+	<-b.release
+	return nil
+}
+
+func startListener(t *testing.T, cfg Config, sink Sink) (*Listener, context.CancelFunc, *errgroup.Group) {
+	t.Helper()
+	cfg.Address = "127.0.0.1:0"
+	l := NewListener(cfg, sink, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runner, ctx := errgroup.WithContext(ctx)
+	require.NoError(t, l.Run(ctx, runner))
+	return l, cancel, runner
+}
+
+func TestListener(t *testing.T) {
+	t.Run("Ingests lines", func(t *testing.T) {
+		sink := &recordingSink{}
+		l, cancel, runner := startListener(t, Config{}, sink)
+
+		conn, err := net.Dial("tcp", l.listener.Addr().String())
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("a.load 1 1700000000\nbroken\nb.load;host=x 2 1700000000\n"))
+		require.NoError(t, err)
+
+		assert.Eventually(t, func() bool { return sink.count() == 2 }, time.Second, 10*time.Millisecond)
+
+		cancel()
+		require.NoError(t, runner.Wait(), "shutdown closes open connections")
+		conn.Close()
+	})
+
+	t.Run("Flushes pending lines on disconnect", func(t *testing.T) {
+		sink := &recordingSink{}
+		l, cancel, runner := startListener(t, Config{}, sink)
+
+		conn, err := net.Dial("tcp", l.listener.Addr().String())
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("a.load 1 1700000000"))
+		require.NoError(t, err)
+		conn.Close()
+
+		assert.Eventually(t, func() bool { return sink.count() == 1 }, time.Second, 10*time.Millisecond)
+		cancel()
+		require.NoError(t, runner.Wait())
+	})
+
+	t.Run("Long line closes the connection", func(t *testing.T) {
+		sink := &recordingSink{}
+		l, cancel, runner := startListener(t, Config{}, sink)
+		defer func() {
+			cancel()
+			runner.Wait()
+		}()
+
+		conn, err := net.Dial("tcp", l.listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("a.load 1 1700000000\n" + strings.Repeat("a", maxLineSize+1)))
+		require.NoError(t, err)
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, os.ErrDeadlineExceeded, "the listener closes the connection")
+		assert.Equal(t, 1, sink.count(), "lines before the long one are kept")
+	})
+
+	t.Run("Shutdown waits for pending writes", func(t *testing.T) {
+		release := make(chan struct{})
+		sink := &blockingSink{release: release, entered: make(chan struct{})}
+		l, cancel, runner := startListener(t, Config{}, sink)
+
+		conn, err := net.Dial("tcp", l.listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("a.load 1 1700000000\n"))
+		require.NoError(t, err)
+		<-sink.entered
+
+		cancel()
+		stopped := make(chan error)
+		go func() { stopped <- runner.Wait() }()
+		select {
+		case <-stopped:
+			t.Fatal("the listener stopped while a write was in flight")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		require.NoError(t, <-stopped)
+	})
+
+	t.Run("Connection limit", func(t *testing.T) {
+		sink := &recordingSink{}
+		l, cancel, runner := startListener(t, Config{MaxConns: 1}, sink)
+		defer func() {
+			cancel()
+			runner.Wait()
+		}()
+
+		first, err := net.Dial("tcp", l.listener.Addr().String())
+		require.NoError(t, err)
+		defer first.Close()
+		_, err = first.Write([]byte("a.load 1 1700000000\n"))
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool { return sink.count() == 1 }, time.Second, 10*time.Millisecond)
+
+		second, err := net.Dial("tcp", l.listener.Addr().String())
+		require.NoError(t, err)
+		defer second.Close()
+		second.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = second.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF, "connections over the limit are closed")
+	})
+}
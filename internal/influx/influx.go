@@ -0,0 +1,296 @@
+package influx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/cumulative"
+	"github.com/Heidric/metrics.git/internal/model"
+)
+
+type FieldType int
+
+const (
+	FloatField FieldType = iota
+	IntegerField
+	BoolField
+	StringField
+)
+
+type Field struct {
+	Key   string
+	Type  FieldType
+	Value float64
+	Int   int64
+}
+
+type Point struct {
+	Measurement string
+	Tags        model.Labels
+	Fields      []Field
+}
+
+type LineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Rule maps integer fields whose metric name matches Pattern (path.Match
+// syntax) to Type, which is either counter or gauge. Counter fields must be
+// cumulative totals: only their increase between writes is stored.
+type Rule struct {
+	Pattern string
+	Type    string
+}
+
+// ParseRules parses pattern=type specifications such as "*_total=counter".
+func ParseRules(specs []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(specs))
+	for _, spec := range specs {
+		pattern, mtype, ok := strings.Cut(strings.TrimSpace(spec), "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("invalid influx rule %q", spec)
+		}
+		if mtype != model.CounterType && mtype != model.GaugeType {
+			return nil, fmt.Errorf("invalid influx rule %q: type must be counter or gauge", spec)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid influx rule %q: %w", spec, err)
+		}
+		rules = append(rules, Rule{Pattern: pattern, Type: mtype})
+	}
+	return rules, nil
+}
+
+// IntegerType returns the metric type for an integer field. The first
+// matching rule wins and unmatched fields are gauges.
+func IntegerType(rules []Rule, name string) string {
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.Pattern, name); ok {
+			return rule.Type
+		}
+	}
+	return model.GaugeType
+}
+
+// ParsePrecision accepts both the v1 (n, u, m, h) and v2 (ns, us, ms, s)
+// precision names. An empty value means nanoseconds.
+func ParsePrecision(v string) (time.Duration, error) {
+	switch v {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µ":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("invalid precision %q", v)
+	}
+}
+
+// Parse parses a line protocol body. Lines that fail to parse are reported
+// individually and do not stop the remaining lines from being parsed.
+func Parse(body []byte, precision time.Duration) ([]Point, []LineError) {
+	var points []Point
+	var lineErrors []LineError
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		point, err := ParseLine(line, precision)
+		if err != nil {
+			lineErrors = append(lineErrors, LineError{Line: n, Message: err.Error()})
+			continue
+		}
+		points = append(points, point)
+	}
+	if err := scanner.Err(); err != nil {
+		lineErrors = append(lineErrors, LineError{Message: err.Error()})
+	}
+	return points, lineErrors
+}
+
+// ParseLine parses a single line. The timestamp is validated but the point is
+// stored as of now, like every other write.
+func ParseLine(line string, precision time.Duration) (Point, error) {
+	var p Point
+
+	end := indexUnescaped(line, ' ', false)
+	if end < 0 {
+		return p, errors.New("missing fields")
+	}
+	key, rest := line[:end], strings.TrimLeft(line[end+1:], " ")
+
+	end = indexUnescaped(rest, ' ', true)
+	fields, timestamp := rest, ""
+	if end >= 0 {
+		fields, timestamp = rest[:end], strings.TrimSpace(rest[end+1:])
+	}
+
+	parts := splitUnescaped(key, ',', false)
+	p.Measurement = unescape(parts[0])
+	if p.Measurement == "" {
+		return p, errors.New("missing measurement")
+	}
+	for _, tag := range parts[1:] {
+		i := indexUnescaped(tag, '=', false)
+		if i <= 0 || i == len(tag)-1 {
+			return p, fmt.Errorf("invalid tag %q", tag)
+		}
+		if p.Tags == nil {
+			p.Tags = make(model.Labels)
+		}
+		p.Tags[unescape(tag[:i])] = unescape(tag[i+1:])
+	}
+	if err := p.Tags.Validate(); err != nil {
+		return p, errors.New("tag keys must be valid label names")
+	}
+
+	if fields == "" {
+		return p, errors.New("missing fields")
+	}
+	for _, field := range splitUnescaped(fields, ',', true) {
+		i := indexUnescaped(field, '=', false)
+		if i <= 0 || i == len(field)-1 {
+			return p, fmt.Errorf("invalid field %q", field)
+		}
+		f, err := parseFieldValue(field[i+1:])
+		if err != nil {
+			return p, fmt.Errorf("invalid value for field %q: %w", unescape(field[:i]), err)
+		}
+		f.Key = unescape(field[:i])
+		p.Fields = append(p.Fields, f)
+	}
+
+	if timestamp != "" {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || ts > math.MaxInt64/int64(precision) || ts < math.MinInt64/int64(precision) {
+			return p, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+	}
+	return p, nil
+}
+
+func parseFieldValue(v string) (Field, error) {
+	switch {
+	case v[0] == '"':
+		if len(v) < 2 || v[len(v)-1] != '"' {
+			return Field{}, errors.New("unterminated string")
+		}
+		return Field{Type: StringField}, nil
+	case strings.HasSuffix(v, "i"):
+		i, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return Field{}, err
+		}
+		return Field{Type: IntegerField, Int: i, Value: float64(i)}, nil
+	case strings.HasSuffix(v, "u"):
+		u, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return Field{}, err
+		}
+		if u > math.MaxInt64 {
+			return Field{}, errors.New("unsigned value out of range")
+		}
+		return Field{Type: IntegerField, Int: int64(u), Value: float64(u)}, nil
+	}
+
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return Field{Type: BoolField, Value: 1}, nil
+	case "f", "F", "false", "False", "FALSE":
+		return Field{Type: BoolField}, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return Field{}, errors.New("not a number")
+	}
+	return Field{Type: FloatField, Value: f}, nil
+}
+
+// Metrics converts the point into one metric per numeric field, named
+// measurement_field. String fields are skipped. Counter fields are
+// cumulative, as Telegraf reports them, and are differenced by counters.
+func (p Point) Metrics(rules []Rule, counters *cumulative.Batch) []*model.Metrics {
+	result := make([]*model.Metrics, 0, len(p.Fields))
+	for _, f := range p.Fields {
+		if f.Type == StringField {
+			continue
+		}
+		m := &model.Metrics{ID: p.Measurement + "_" + f.Key, MType: model.GaugeType, Labels: p.Tags}
+		if f.Type == IntegerField && IntegerType(rules, m.ID) == model.CounterType {
+			delta := counters.Delta(m.Key(), 0, float64(f.Int))
+			m.MType, m.Delta = model.CounterType, &delta
+		} else {
+			value := f.Value
+			m.Value = &value
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+// indexUnescaped returns the index of the first sep in s that is neither
+// backslash-escaped nor, when quoted is set, inside a double-quoted string.
+func indexUnescaped(s string, sep byte, quoted bool) int {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case quoted && c == '"':
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			return i
+		}
+	}
+	return -1
+}
+
+func splitUnescaped(s string, sep byte, quoted bool) []string {
+	var parts []string
+	for {
+		i := indexUnescaped(s, sep, quoted)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+func unescape(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`, ="\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,105 @@
+package influx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/cumulative"
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	t.Run("Full line", func(t *testing.T) {
+		p, err := ParseLine(`cpu,host=a,region=eu usage=0.5,cores=8i,up=true,free=3u,note="a b, c" 1700000000000000000`, time.Nanosecond)
+		require.NoError(t, err)
+		assert.Equal(t, "cpu", p.Measurement)
+		assert.Equal(t, model.Labels{"host": "a", "region": "eu"}, p.Tags)
+		assert.Equal(t, []Field{
+			{Key: "usage", Type: FloatField, Value: 0.5},
+			{Key: "cores", Type: IntegerField, Int: 8, Value: 8},
+			{Key: "up", Type: BoolField, Value: 1},
+			{Key: "free", Type: IntegerField, Int: 3, Value: 3},
+			{Key: "note", Type: StringField},
+		}, p.Fields)
+	})
+
+	t.Run("Escapes and precision", func(t *testing.T) {
+		p, err := ParseLine(`disk\ io,path=/var\,log read\ ops=1 1700000000`, time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, "disk io", p.Measurement)
+		assert.Equal(t, model.Labels{"path": "/var,log"}, p.Tags)
+		assert.Equal(t, "read ops", p.Fields[0].Key)
+	})
+
+	t.Run("Timestamp out of range", func(t *testing.T) {
+		_, err := ParseLine("mem used=1 9223372036854775807", time.Nanosecond)
+		assert.NoError(t, err)
+		_, err = ParseLine("mem used=1 9223372037", time.Second)
+		assert.Error(t, err, "seconds that overflow nanoseconds are rejected")
+		_, err = ParseLine("mem used=1 -9223372037", time.Second)
+		assert.Error(t, err)
+	})
+
+	for _, line := range []string{
+		"cpu",
+		"cpu ",
+		",host=a usage=1",
+		"cpu,host usage=1",
+		"cpu,bad-tag=a usage=1",
+		"cpu usage",
+		"cpu usage=abc",
+		"cpu usage=NaN",
+		"cpu usage=1.5i",
+		`cpu note="open`,
+		"cpu usage=1 soon",
+	} {
+		t.Run("Invalid "+line, func(t *testing.T) {
+			_, err := ParseLine(line, time.Nanosecond)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	body := "# comment\ncpu usage=1\n\ncpu usage=oops\nmem used=2i\n"
+	points, lineErrors := Parse([]byte(body), time.Nanosecond)
+	require.Len(t, points, 2)
+	require.Len(t, lineErrors, 1)
+	assert.Equal(t, 4, lineErrors[0].Line)
+}
+
+func TestRules(t *testing.T) {
+	_, err := ParseRules([]string{"*_total"})
+	assert.Error(t, err)
+	_, err = ParseRules([]string{"*_total=histogram"})
+	assert.Error(t, err)
+	_, err = ParseRules([]string{"[=counter"})
+	assert.Error(t, err)
+
+	rules, err := ParseRules([]string{"net_drop*=gauge", "net_*=counter"})
+	require.NoError(t, err)
+	assert.Equal(t, model.CounterType, IntegerType(rules, "net_bytes_recv"))
+	assert.Equal(t, model.GaugeType, IntegerType(rules, "net_drop_in"))
+	assert.Equal(t, model.GaugeType, IntegerType(rules, "mem_used"))
+
+	p, err := ParseLine(`net,iface=eth0 bytes_recv=10i,drop_in=2i,err_rate=0.1,up=t,name="eth0"`, time.Nanosecond)
+	require.NoError(t, err)
+	counters := cumulative.NewTracker().Begin()
+	defer counters.Commit()
+	metrics := p.Metrics(rules, counters)
+	require.Len(t, metrics, 4)
+	assert.Equal(t, "net_bytes_recv", metrics[0].ID)
+	assert.Equal(t, model.CounterType, metrics[0].MType)
+	assert.Equal(t, int64(0), *metrics[0].Delta, "the first counter value is the baseline")
+	assert.Equal(t, model.GaugeType, metrics[1].MType)
+	assert.Equal(t, 2.0, *metrics[1].Value)
+	assert.Equal(t, 0.1, *metrics[2].Value)
+	assert.Equal(t, 1.0, *metrics[3].Value)
+	assert.Equal(t, model.Labels{"iface": "eth0"}, metrics[3].Labels)
+
+	p.Fields[0].Int = 25
+	metrics = p.Metrics(rules, counters)
+	assert.Equal(t, int64(15), *metrics[0].Delta, "counters store the increase")
+}
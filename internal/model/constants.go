@@ -2,3 +2,5 @@ package model
 
 const GaugeType = "gauge"
 const CounterType = "counter"
+const HistogramType = "histogram"
+const SummaryType = "summary"
@@ -0,0 +1,141 @@
+package model
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Heidric/metrics.git/internal/customerrors"
+)
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+type Histogram struct {
+	Buckets []Bucket `json:"buckets"`
+	Sum     float64  `json:"sum"`
+	Count   uint64   `json:"count"`
+}
+
+type Quantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type Summary struct {
+	Quantiles []Quantile `json:"quantiles"`
+	Sum       float64    `json:"sum"`
+	Count     uint64     `json:"count"`
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultBuckets
+	}
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+
+	h := &Histogram{Buckets: make([]Bucket, 0, len(sorted))}
+	for i, b := range sorted {
+		if i > 0 && b == sorted[i-1] {
+			continue
+		}
+		h.Buckets = append(h.Buckets, Bucket{UpperBound: b})
+	}
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.ObserveN(v, 1)
+}
+
+// ObserveN records v as if it had been observed n times.
+func (h *Histogram) ObserveN(v float64, n uint64) {
+	for i := range h.Buckets {
+		if v <= h.Buckets[i].UpperBound {
+			h.Buckets[i].Count += n
+		}
+	}
+	h.Sum += v * float64(n)
+	h.Count += n
+}
+
+func (h *Histogram) Validate() error {
+	if h == nil || math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return customerrors.ErrInvalidValue
+	}
+	for i, b := range h.Buckets {
+		if math.IsNaN(b.UpperBound) || math.IsInf(b.UpperBound, 0) || b.Count > h.Count {
+			return customerrors.ErrInvalidValue
+		}
+		if i > 0 && (b.UpperBound <= h.Buckets[i-1].UpperBound || b.Count < h.Buckets[i-1].Count) {
+			return customerrors.ErrInvalidValue
+		}
+	}
+	return nil
+}
+
+func (h *Histogram) String() string {
+	var b strings.Builder
+	b.WriteString("count=" + strconv.FormatUint(h.Count, 10))
+	b.WriteString(" sum=" + strconv.FormatFloat(h.Sum, 'f', -1, 64))
+	b.WriteString(" buckets=[")
+	for _, bucket := range h.Buckets {
+		b.WriteString(strconv.FormatFloat(bucket.UpperBound, 'f', -1, 64) + ":" + strconv.FormatUint(bucket.Count, 10) + " ")
+	}
+	b.WriteString("+Inf:" + strconv.FormatUint(h.Count, 10) + "]")
+	return b.String()
+}
+
+func (s *Summary) Validate() error {
+	if s == nil || math.IsNaN(s.Sum) || math.IsInf(s.Sum, 0) {
+		return customerrors.ErrInvalidValue
+	}
+	for i, q := range s.Quantiles {
+		if math.IsNaN(q.Quantile) || q.Quantile < 0 || q.Quantile > 1 || math.IsInf(q.Value, 0) {
+			return customerrors.ErrInvalidValue
+		}
+		if i > 0 && q.Quantile <= s.Quantiles[i-1].Quantile {
+			return customerrors.ErrInvalidValue
+		}
+	}
+	return nil
+}
+
+func (s *Summary) String() string {
+	var b strings.Builder
+	b.WriteString("count=" + strconv.FormatUint(s.Count, 10))
+	b.WriteString(" sum=" + strconv.FormatFloat(s.Sum, 'f', -1, 64))
+	b.WriteString(" quantiles=[")
+	for i, q := range s.Quantiles {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.FormatFloat(q.Quantile, 'f', -1, 64) + ":" + strconv.FormatFloat(q.Value, 'f', -1, 64))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+func (h *Histogram) Clone() *Histogram {
+	if h == nil {
+		return nil
+	}
+	c := *h
+	c.Buckets = append([]Bucket(nil), h.Buckets...)
+	return &c
+}
+
+func (s *Summary) Clone() *Summary {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	c.Quantiles = append([]Quantile(nil), s.Quantiles...)
+	return &c
+}
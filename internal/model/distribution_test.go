@@ -0,0 +1,77 @@
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	t.Run("Observe fills cumulative buckets", func(t *testing.T) {
+		h := NewHistogram([]float64{1, 0.1, 1})
+		for _, v := range []float64{0.05, 0.5, 2} {
+			h.Observe(v)
+		}
+
+		assert.Equal(t, []Bucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 2}}, h.Buckets)
+		assert.Equal(t, uint64(3), h.Count)
+		assert.InDelta(t, 2.55, h.Sum, 1e-9)
+		assert.NoError(t, h.Validate())
+		assert.Equal(t, "count=3 sum=2.55 buckets=[0.1:1 1:2 +Inf:3]", h.String())
+	})
+
+	t.Run("ObserveN weights a single value", func(t *testing.T) {
+		h := NewHistogram([]float64{0.1, 1})
+		h.ObserveN(0.5, 1e9)
+
+		assert.Equal(t, []Bucket{{UpperBound: 0.1}, {UpperBound: 1, Count: 1e9}}, h.Buckets)
+		assert.Equal(t, uint64(1e9), h.Count)
+		assert.InDelta(t, 5e8, h.Sum, 1e-3)
+	})
+
+	t.Run("Default buckets", func(t *testing.T) {
+		assert.Len(t, NewHistogram(nil).Buckets, len(DefaultBuckets))
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		assert.Error(t, (*Histogram)(nil).Validate())
+		assert.Error(t, (&Histogram{Buckets: []Bucket{{1, 2}, {0.5, 2}}, Count: 2}).Validate(), "unsorted bounds")
+		assert.Error(t, (&Histogram{Buckets: []Bucket{{1, 2}, {2, 1}}, Count: 2}).Validate(), "decreasing counts")
+		assert.Error(t, (&Histogram{Buckets: []Bucket{{1, 3}}, Count: 2}).Validate(), "bucket above total")
+		assert.Error(t, (&Histogram{Sum: math.NaN()}).Validate())
+	})
+
+	t.Run("Clone is independent", func(t *testing.T) {
+		h := NewHistogram([]float64{1})
+		c := h.Clone()
+		c.Observe(0.5)
+		assert.Equal(t, uint64(0), h.Buckets[0].Count)
+	})
+
+	t.Run("JSON wire format", func(t *testing.T) {
+		var m Metrics
+		require.NoError(t, json.Unmarshal([]byte(`{"id":"latency","type":"histogram",
+			"histogram":{"buckets":[{"le":0.1,"count":1},{"le":1,"count":4}],"sum":2.5,"count":5}}`), &m))
+		require.NotNil(t, m.Histogram)
+		assert.Equal(t, HistogramType, m.MType)
+		assert.Equal(t, uint64(5), m.Histogram.Count)
+		assert.NoError(t, m.Histogram.Validate())
+	})
+}
+
+func TestSummary(t *testing.T) {
+	s := &Summary{
+		Quantiles: []Quantile{{0.5, 0.2}, {0.99, 1.5}},
+		Sum:       10,
+		Count:     20,
+	}
+	assert.NoError(t, s.Validate())
+	assert.Equal(t, "count=20 sum=10 quantiles=[0.5:0.2 0.99:1.5]", s.String())
+
+	assert.Error(t, (*Summary)(nil).Validate())
+	assert.Error(t, (&Summary{Quantiles: []Quantile{{1.5, 1}}}).Validate(), "quantile above 1")
+	assert.Error(t, (&Summary{Quantiles: []Quantile{{0.9, 1}, {0.5, 1}}}).Validate(), "unsorted quantiles")
+}
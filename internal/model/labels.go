@@ -0,0 +1,123 @@
+package model
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Heidric/metrics.git/internal/customerrors"
+)
+
+type Labels map[string]string
+
+func (l Labels) Validate() error {
+	for name := range l {
+		if !validLabelName(name) {
+			return customerrors.ErrInvalidLabels
+		}
+	}
+	return nil
+}
+
+func validLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(l[name]))
+	}
+	return b.String()
+}
+
+func ParseLabels(s string) (Labels, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	labels := make(Labels)
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, customerrors.ErrInvalidLabels
+		}
+		name := s[:eq]
+		if !validLabelName(name) {
+			return nil, customerrors.ErrInvalidLabels
+		}
+
+		quoted, err := strconv.QuotedPrefix(s[eq+1:])
+		if err != nil {
+			return nil, customerrors.ErrInvalidLabels
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, customerrors.ErrInvalidLabels
+		}
+		labels[name] = value
+
+		s = s[eq+1+len(quoted):]
+		if s != "" {
+			if s[0] != ',' {
+				return nil, customerrors.ErrInvalidLabels
+			}
+			s = s[1:]
+		}
+	}
+	return labels, nil
+}
+
+// ValidateID rejects metric IDs containing the characters series keys use to
+// delimit labels, so an unlabelled a{b="c"} cannot collide with a labelled a.
+func ValidateID(id string) error {
+	if strings.ContainsAny(id, "{},") {
+		return customerrors.ErrInvalidValue
+	}
+	return nil
+}
+
+func SeriesKey(name string, labels Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+	return name + "{" + labels.String() + "}"
+}
+
+func ParseSeriesKey(key string) (string, Labels, error) {
+	open := strings.IndexByte(key, '{')
+	if open < 0 || !strings.HasSuffix(key, "}") {
+		return key, nil, nil
+	}
+	labels, err := ParseLabels(key[open+1 : len(key)-1])
+	if err != nil {
+		return "", nil, err
+	}
+	return key[:open], labels, nil
+}
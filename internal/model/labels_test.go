@@ -0,0 +1,58 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabels(t *testing.T) {
+	t.Run("Canonical string is sorted and quoted", func(t *testing.T) {
+		labels := Labels{"host": "web-1", "agent_id": `a"b`}
+		assert.Equal(t, `agent_id="a\"b",host="web-1"`, labels.String())
+		assert.Equal(t, "", Labels(nil).String())
+	})
+
+	t.Run("Series key round trip", func(t *testing.T) {
+		labels := Labels{"host": "web-1", "dc": "eu,west", "note": "a=b}"}
+		key := SeriesKey("Alloc", labels)
+
+		name, parsed, err := ParseSeriesKey(key)
+		require.NoError(t, err)
+		assert.Equal(t, "Alloc", name)
+		assert.Equal(t, labels, parsed)
+	})
+
+	t.Run("Key without labels", func(t *testing.T) {
+		m := &Metrics{ID: "Alloc", MType: GaugeType}
+		assert.Equal(t, "Alloc", m.Key())
+
+		name, labels, err := ParseSeriesKey("Alloc")
+		require.NoError(t, err)
+		assert.Equal(t, "Alloc", name)
+		assert.Nil(t, labels)
+	})
+
+	t.Run("ID validation", func(t *testing.T) {
+		assert.NoError(t, ValidateID("http.requests_total"))
+		for _, id := range []string{`a{b="c"}`, "a}", "a,b"} {
+			assert.ErrorIs(t, ValidateID(id), customerrors.ErrInvalidValue, id)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		assert.NoError(t, Labels{"host": "x", "agent_id2": ""}.Validate())
+		assert.ErrorIs(t, Labels{"2host": "x"}.Validate(), customerrors.ErrInvalidLabels)
+		assert.ErrorIs(t, Labels{"ho-st": "x"}.Validate(), customerrors.ErrInvalidLabels)
+		assert.ErrorIs(t, Labels{"": "x"}.Validate(), customerrors.ErrInvalidLabels)
+	})
+
+	t.Run("Malformed label string", func(t *testing.T) {
+		for _, s := range []string{`host`, `host="x`, `host="x"dc="y"`, `=""`} {
+			_, err := ParseLabels(s)
+			assert.Error(t, err, s)
+		}
+	})
+}
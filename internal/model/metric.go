@@ -1,8 +1,29 @@
 package model
 
+import "time"
+
 type Metrics struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
+	ID        string     `json:"id"`
+	MType     string     `json:"type"`
+	Delta     *int64     `json:"delta,omitempty"`
+	Value     *float64   `json:"value,omitempty"`
+	Histogram *Histogram `json:"histogram,omitempty"`
+	Summary   *Summary   `json:"summary,omitempty"`
+	Labels    Labels     `json:"labels,omitempty"`
+}
+
+func (m *Metrics) Key() string {
+	return SeriesKey(m.ID, m.Labels)
+}
+
+type Point struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+type Series struct {
+	ID     string  `json:"id"`
+	MType  string  `json:"type"`
+	Labels Labels  `json:"labels,omitempty"`
+	Points []Point `json:"points"`
 }
@@ -0,0 +1,169 @@
+package otlp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Heidric/metrics.git/internal/cumulative"
+	"github.com/Heidric/metrics.git/internal/model"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// Convert returns the metrics carried by req together with the partial
+// success to report for the data points that could not be mapped. Counters
+// in storage are additive, so cumulative sums are differenced by counters and
+// delta sums are added up there, carrying their fractions between exports.
+func Convert(req *colmetricspb.ExportMetricsServiceRequest, counters *cumulative.Batch) ([]*model.Metrics, *colmetricspb.ExportMetricsPartialSuccess) {
+	var result []*model.Metrics
+	var rejected int64
+	var unsupported []string
+	for _, rm := range req.GetResourceMetrics() {
+		resource := attributesToLabels(nil, rm.GetResource().GetAttributes())
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, metric := range sm.GetMetrics() {
+				switch {
+				case metric.GetGauge() != nil:
+					for _, dp := range metric.GetGauge().GetDataPoints() {
+						if noValue(dp) {
+							continue
+						}
+						value := pointValue(dp)
+						result = append(result, &model.Metrics{
+							ID:     metric.GetName(),
+							MType:  model.GaugeType,
+							Value:  &value,
+							Labels: attributesToLabels(resource, dp.GetAttributes()),
+						})
+					}
+				case metric.GetSum() != nil:
+					result = append(result, convertSum(metric.GetName(), metric.GetSum(), resource, counters)...)
+				default:
+					n := dataPointCount(metric)
+					if n > 0 {
+						rejected += n
+						unsupported = append(unsupported, metric.GetName())
+					}
+				}
+			}
+		}
+	}
+
+	if rejected == 0 {
+		return result, nil
+	}
+	return result, &colmetricspb.ExportMetricsPartialSuccess{
+		RejectedDataPoints: rejected,
+		ErrorMessage:       fmt.Sprintf("unsupported metric types for %s", strings.Join(unsupported, ", ")),
+	}
+}
+
+// convertSum maps monotonic sums to counters. Non-monotonic cumulative sums
+// are current values and become gauges, while non-monotonic delta sums are
+// still additive and stay counters.
+func convertSum(name string, sum *metricspb.Sum, resource model.Labels, counters *cumulative.Batch) []*model.Metrics {
+	temporality := sum.GetAggregationTemporality()
+	var result []*model.Metrics
+	for _, dp := range sum.GetDataPoints() {
+		if noValue(dp) {
+			continue
+		}
+		labels := attributesToLabels(resource, dp.GetAttributes())
+		value := pointValue(dp)
+
+		if temporality == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE && !sum.GetIsMonotonic() {
+			result = append(result, &model.Metrics{ID: name, MType: model.GaugeType, Value: &value, Labels: labels})
+			continue
+		}
+
+		var delta int64
+		if temporality == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			delta = counters.Delta(model.SeriesKey(name, labels), dp.GetStartTimeUnixNano(), value)
+		} else {
+			delta = counters.Add(model.SeriesKey(name, labels), value)
+		}
+		result = append(result, &model.Metrics{ID: name, MType: model.CounterType, Delta: &delta, Labels: labels})
+	}
+	return result
+}
+
+func noValue(dp *metricspb.NumberDataPoint) bool {
+	return dp.GetFlags()&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0
+}
+
+func pointValue(dp *metricspb.NumberDataPoint) float64 {
+	if v, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return dp.GetAsDouble()
+}
+
+func dataPointCount(metric *metricspb.Metric) int64 {
+	switch {
+	case metric.GetHistogram() != nil:
+		return int64(len(metric.GetHistogram().GetDataPoints()))
+	case metric.GetExponentialHistogram() != nil:
+		return int64(len(metric.GetExponentialHistogram().GetDataPoints()))
+	case metric.GetSummary() != nil:
+		return int64(len(metric.GetSummary().GetDataPoints()))
+	default:
+		return 0
+	}
+}
+
+// attributesToLabels layers attrs over base. Keys are sanitised into label
+// names ("service.name" becomes "service_name") and attributes that are not
+// scalars are dropped.
+func attributesToLabels(base model.Labels, attrs []*commonpb.KeyValue) model.Labels {
+	if len(base) == 0 && len(attrs) == 0 {
+		return nil
+	}
+	labels := make(model.Labels, len(base)+len(attrs))
+	for k, v := range base {
+		labels[k] = v
+	}
+	for _, attr := range attrs {
+		value, ok := attributeValue(attr.GetValue())
+		if !ok {
+			continue
+		}
+		labels[sanitizeLabelName(attr.GetKey())] = value
+	}
+	return labels
+}
+
+func attributeValue(v *commonpb.AnyValue) (string, bool) {
+	switch v := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue, true
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue), true
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10), true
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+func sanitizeLabelName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9':
+		default:
+			b[i] = '_'
+		}
+	}
+	if b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
@@ -0,0 +1,133 @@
+package otlp
+
+import (
+	"testing"
+
+	"github.com/Heidric/metrics.git/internal/cumulative"
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func intPoint(start uint64, v int64, attrs ...*commonpb.KeyValue) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{StartTimeUnixNano: start, Attributes: attrs, Value: &metricspb.NumberDataPoint_AsInt{AsInt: v}}
+}
+
+func doublePoint(v float64, attrs ...*commonpb.KeyValue) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{Attributes: attrs, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: v}}
+}
+
+func request(metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource:     &resourcepb.Resource{Attributes: []*commonpb.KeyValue{stringAttr("service.name", "api")}},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+	}}}
+}
+
+func sum(name string, temporality metricspb.AggregationTemporality, monotonic bool, points ...*metricspb.NumberDataPoint) *metricspb.Metric {
+	return &metricspb.Metric{Name: name, Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+		AggregationTemporality: temporality,
+		IsMonotonic:            monotonic,
+		DataPoints:             points,
+	}}}
+}
+
+const (
+	cumulativeTemporality = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	deltaTemporality      = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+)
+
+func TestConvert(t *testing.T) {
+	t.Run("Gauges and labels", func(t *testing.T) {
+		metrics, partial := Convert(request(&metricspb.Metric{Name: "queue.size", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{
+				doublePoint(1.5, stringAttr("queue", "jobs"), stringAttr("service.name", "worker")),
+				{Flags: uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK)},
+			},
+		}}}), nil)
+
+		assert.Nil(t, partial)
+		require.Len(t, metrics, 1)
+		assert.Equal(t, "queue.size", metrics[0].ID)
+		assert.Equal(t, model.GaugeType, metrics[0].MType)
+		assert.Equal(t, 1.5, *metrics[0].Value)
+		assert.Equal(t, model.Labels{"service_name": "worker", "queue": "jobs"}, metrics[0].Labels, "point attributes override resource attributes")
+	})
+
+	t.Run("Cumulative sums become deltas", func(t *testing.T) {
+		tracker := cumulative.NewTracker()
+		deltas := func(start uint64, v int64) int64 {
+			counters := tracker.Begin()
+			defer counters.Commit()
+			metrics, _ := Convert(request(sum("requests", cumulativeTemporality, true, intPoint(start, v))), counters)
+			require.Len(t, metrics, 1)
+			assert.Equal(t, model.CounterType, metrics[0].MType)
+			return *metrics[0].Delta
+		}
+
+		assert.Equal(t, int64(0), deltas(1, 10), "first point only sets the baseline")
+		assert.Equal(t, int64(5), deltas(1, 15))
+		assert.Equal(t, int64(0), deltas(1, 15))
+		assert.Equal(t, int64(3), deltas(2, 3), "new start time resets the series")
+		assert.Equal(t, int64(1), deltas(2, 1), "a decrease is treated as a reset")
+	})
+
+	t.Run("Delta and non-monotonic sums", func(t *testing.T) {
+		metrics, _ := Convert(request(
+			sum("bytes", deltaTemporality, true, intPoint(0, 7)),
+			sum("bytes", deltaTemporality, true, intPoint(0, 7)),
+			sum("inflight", cumulativeTemporality, false, intPoint(0, 4)),
+			sum("balance", deltaTemporality, false, doublePoint(-2.4)),
+		), cumulative.NewTracker().Begin())
+		require.Len(t, metrics, 4)
+		assert.Equal(t, int64(7), *metrics[0].Delta)
+		assert.Equal(t, int64(7), *metrics[1].Delta, "delta sums are not differenced")
+		assert.Equal(t, model.GaugeType, metrics[2].MType)
+		assert.Equal(t, 4.0, *metrics[2].Value)
+		assert.Equal(t, model.CounterType, metrics[3].MType)
+		assert.Equal(t, int64(-2), *metrics[3].Delta)
+	})
+
+	t.Run("Fractional delta sums", func(t *testing.T) {
+		counters := cumulative.NewTracker().Begin()
+		defer counters.Commit()
+		metrics, _ := Convert(request(sum("cpu.time", deltaTemporality, true, doublePoint(0.3), doublePoint(0.3))), counters)
+		require.Len(t, metrics, 2)
+		assert.Equal(t, int64(0), *metrics[0].Delta)
+		assert.Equal(t, int64(1), *metrics[1].Delta, "fractions add up instead of being rounded away")
+
+		metrics, _ = Convert(request(sum("cpu.time", deltaTemporality, true, doublePoint(0.3), doublePoint(0.3))), counters)
+		assert.Equal(t, int64(0), *metrics[0].Delta)
+		assert.Equal(t, int64(0), *metrics[1].Delta, "the total of 1.2 was already counted as 1")
+	})
+
+	t.Run("Unsupported types are rejected", func(t *testing.T) {
+		metrics, partial := Convert(request(&metricspb.Metric{Name: "latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			DataPoints: []*metricspb.HistogramDataPoint{{}, {}},
+		}}}), nil)
+		assert.Empty(t, metrics)
+		require.NotNil(t, partial)
+		assert.Equal(t, int64(2), partial.RejectedDataPoints)
+		assert.Contains(t, partial.ErrorMessage, "latency")
+	})
+}
+
+func TestSanitizeLabelName(t *testing.T) {
+	tests := map[string]string{
+		"service.name": "service_name",
+		"http_method":  "http_method",
+		"9lives":       "_9lives",
+		"":             "_",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, sanitizeLabelName(in), in)
+	}
+}
@@ -0,0 +1,319 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/model"
+)
+
+const (
+	entrySuffix = ".json"
+	tmpSuffix   = ".tmp"
+)
+
+type Entry struct {
+	ID        uint64           `json:"-"`
+	CreatedAt time.Time        `json:"created_at"`
+	Metrics   []*model.Metrics `json:"metrics"`
+
+	size int64
+}
+
+type Outbox struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries []*Entry
+	size    int64
+	nextID  uint64
+	dropped int
+	// inflight is the ID of the entry handed out by Peek and not yet
+	// removed, replaced or released. Zero means none.
+	inflight uint64
+}
+
+func New(dir string, maxBytes int64, maxAge time.Duration) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+
+	o := &Outbox{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		now:      time.Now,
+		nextID:   1,
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.enforceLimits(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *Outbox) load() error {
+	files, err := os.ReadDir(o.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read outbox directory: %w", err)
+	}
+
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasSuffix(name, tmpSuffix) {
+			os.Remove(filepath.Join(o.dir, name))
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, entrySuffix), 10, 64)
+		if err != nil || !strings.HasSuffix(name, entrySuffix) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(o.dir, name))
+		if err != nil {
+			return fmt.Errorf("failed to read outbox entry: %w", err)
+		}
+		entry := &Entry{ID: id, size: int64(len(data))}
+		if err := json.Unmarshal(data, entry); err != nil {
+			os.Remove(filepath.Join(o.dir, name))
+			o.dropped++
+			continue
+		}
+
+		o.entries = append(o.entries, entry)
+		o.size += entry.size
+		if id >= o.nextID {
+			o.nextID = id + 1
+		}
+	}
+
+	sort.Slice(o.entries, func(i, j int) bool {
+		return o.entries[i].ID < o.entries[j].ID
+	})
+	return nil
+}
+
+func (o *Outbox) Append(metrics []*model.Metrics) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entry := &Entry{
+		ID:        o.nextID,
+		CreatedAt: o.now(),
+		Metrics:   metrics,
+	}
+	if err := o.write(entry); err != nil {
+		return err
+	}
+	o.nextID++
+	o.entries = append(o.entries, entry)
+	o.size += entry.size
+
+	return o.enforceLimits()
+}
+
+// Peek returns a copy of the oldest pending entry without removing it. The
+// entry is in flight until it is passed to Remove, Replace or Release, and
+// the limits leave it alone meanwhile, so its counters are never merged into
+// another entry while they may still be delivered.
+func (o *Outbox) Peek() *Entry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.inflight = 0
+	o.enforceLimits()
+	if len(o.entries) == 0 {
+		return nil
+	}
+	o.inflight = o.entries[0].ID
+	entry := *o.entries[0]
+	entry.Metrics = append([]*model.Metrics(nil), entry.Metrics...)
+	return &entry
+}
+
+func (o *Outbox) Remove(id uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.release(id)
+	for i, entry := range o.entries {
+		if entry.ID == id {
+			return o.removeAt(i)
+		}
+	}
+	return nil
+}
+
+// Replace rewrites a pending entry in place, keeping its position in the queue.
+func (o *Outbox) Replace(id uint64, metrics []*model.Metrics) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.release(id)
+	for _, entry := range o.entries {
+		if entry.ID != id {
+			continue
+		}
+		o.size -= entry.size
+		entry.Metrics = metrics
+		err := o.write(entry)
+		o.size += entry.size
+		return err
+	}
+	return nil
+}
+
+// Release returns a peeked entry to the queue unchanged, for when it could
+// not be delivered.
+func (o *Outbox) Release(id uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.release(id)
+}
+
+func (o *Outbox) release(id uint64) {
+	if o.inflight == id {
+		o.inflight = 0
+	}
+}
+
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+func (o *Outbox) Size() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.size
+}
+
+// Dropped returns how many entries were discarded by the size and age limits
+// since the last call.
+func (o *Outbox) Dropped() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := o.dropped
+	o.dropped = 0
+	return n
+}
+
+// enforceLimits evicts the oldest entries while the outbox is over its limits.
+// Counter deltas of an evicted entry are folded into the next one so that
+// totals survive eviction. The newest entry has nowhere to fold into and is
+// kept even when it is over the limits, as is the entry in flight.
+func (o *Outbox) enforceLimits() error {
+	cutoff := o.now().Add(-o.maxAge)
+	i := 0
+	if len(o.entries) > 0 && o.entries[0].ID == o.inflight {
+		i = 1
+	}
+	for i < len(o.entries)-1 {
+		oldest := o.entries[i]
+		expired := o.maxAge > 0 && oldest.CreatedAt.Before(cutoff)
+		oversized := o.maxBytes > 0 && o.size > o.maxBytes
+		if !expired && !oversized {
+			return nil
+		}
+
+		next := o.entries[i+1]
+		if mergeCounters(next, oldest.Metrics) {
+			o.size -= next.size
+			if err := o.write(next); err != nil {
+				return err
+			}
+			o.size += next.size
+		}
+		if err := o.removeAt(i); err != nil {
+			return err
+		}
+		o.dropped++
+	}
+	return nil
+}
+
+func (o *Outbox) removeAt(i int) error {
+	entry := o.entries[i]
+	if err := os.Remove(o.path(entry.ID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove outbox entry: %w", err)
+	}
+	o.entries = append(o.entries[:i], o.entries[i+1:]...)
+	o.size -= entry.size
+	return nil
+}
+
+func (o *Outbox) write(entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox entry: %w", err)
+	}
+
+	path := o.path(entry.ID)
+	tmp := path + tmpSuffix
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+
+	entry.size = int64(len(data))
+	return nil
+}
+
+func (o *Outbox) path(id uint64) string {
+	return filepath.Join(o.dir, fmt.Sprintf("%020d%s", id, entrySuffix))
+}
+
+func mergeCounters(dst *Entry, src []*model.Metrics) bool {
+	merged := false
+	for _, m := range src {
+		if m.MType != model.CounterType || m.Delta == nil {
+			continue
+		}
+		merged = true
+
+		found := false
+		for i, d := range dst.Metrics {
+			if d.MType == model.CounterType && d.Delta != nil && d.Key() == m.Key() {
+				sum := *d.Delta + *m.Delta
+				updated := *d
+				updated.Delta = &sum
+				dst.Metrics[i] = &updated
+				found = true
+				break
+			}
+		}
+		if !found {
+			delta := *m.Delta
+			dst.Metrics = append(dst.Metrics, &model.Metrics{
+				ID:     m.ID,
+				MType:  m.MType,
+				Delta:  &delta,
+				Labels: m.Labels,
+			})
+		}
+	}
+	return merged
+}
@@ -0,0 +1,157 @@
+package outbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(id string, v float64) *model.Metrics {
+	return &model.Metrics{ID: id, MType: model.GaugeType, Value: &v}
+}
+
+func counter(id string, d int64) *model.Metrics {
+	return &model.Metrics{ID: id, MType: model.CounterType, Delta: &d}
+}
+
+func TestOutbox(t *testing.T) {
+	t.Run("Replays in order and survives restarts", func(t *testing.T) {
+		dir := t.TempDir()
+		box, err := New(dir, 0, 0)
+		require.NoError(t, err)
+
+		require.NoError(t, box.Append([]*model.Metrics{gauge("a", 1)}))
+		require.NoError(t, box.Append([]*model.Metrics{gauge("b", 2)}))
+		require.NoError(t, box.Append(nil))
+		assert.Equal(t, 2, box.Len())
+
+		restored, err := New(dir, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, 2, restored.Len())
+		assert.Equal(t, box.Size(), restored.Size())
+
+		first := restored.Peek()
+		require.NotNil(t, first)
+		assert.Equal(t, "a", first.Metrics[0].ID)
+		require.NoError(t, restored.Remove(first.ID))
+
+		require.NoError(t, restored.Append([]*model.Metrics{gauge("c", 3)}))
+		second := restored.Peek()
+		assert.Equal(t, "b", second.Metrics[0].ID)
+		require.NoError(t, restored.Remove(second.ID))
+		assert.Equal(t, "c", restored.Peek().Metrics[0].ID)
+	})
+
+	t.Run("Replace keeps position", func(t *testing.T) {
+		box, err := New(t.TempDir(), 0, 0)
+		require.NoError(t, err)
+
+		require.NoError(t, box.Append([]*model.Metrics{gauge("a", 1), gauge("b", 2)}))
+		require.NoError(t, box.Append([]*model.Metrics{gauge("c", 3)}))
+
+		first := box.Peek()
+		require.NoError(t, box.Replace(first.ID, first.Metrics[1:]))
+
+		got := box.Peek()
+		assert.Equal(t, first.ID, got.ID)
+		require.Len(t, got.Metrics, 1)
+		assert.Equal(t, "b", got.Metrics[0].ID)
+	})
+
+	t.Run("Size cap evicts oldest and merges counters", func(t *testing.T) {
+		dir := t.TempDir()
+		box, err := New(dir, 0, 0)
+		require.NoError(t, err)
+
+		require.NoError(t, box.Append([]*model.Metrics{gauge("Alloc", 1), counter("PollCount", 3)}))
+		require.NoError(t, box.Append([]*model.Metrics{counter("PollCount", 2), counter("Other", 1)}))
+		size := box.Size()
+
+		capped, err := New(dir, size-1, 0)
+		require.NoError(t, err)
+		require.Equal(t, 1, capped.Len())
+		assert.Equal(t, 1, capped.Dropped())
+		assert.Equal(t, 0, capped.Dropped())
+
+		entry := capped.Peek()
+		deltas := map[string]int64{}
+		for _, m := range entry.Metrics {
+			require.Equal(t, model.CounterType, m.MType, "gauges of evicted batches are dropped")
+			deltas[m.ID] = *m.Delta
+		}
+		assert.Equal(t, map[string]int64{"PollCount": 5, "Other": 1}, deltas)
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		require.NoError(t, err)
+		assert.Len(t, files, 1)
+	})
+
+	t.Run("Newest entry is kept over the cap", func(t *testing.T) {
+		box, err := New(t.TempDir(), 1, 0)
+		require.NoError(t, err)
+
+		require.NoError(t, box.Append([]*model.Metrics{counter("PollCount", 3)}))
+		require.Equal(t, 1, box.Len())
+		assert.Equal(t, 0, box.Dropped())
+
+		require.NoError(t, box.Append([]*model.Metrics{counter("PollCount", 2)}))
+		entry := box.Peek()
+		require.Equal(t, 1, box.Len())
+		assert.Equal(t, int64(5), *entry.Metrics[0].Delta)
+	})
+
+	t.Run("Entry in flight is not merged away", func(t *testing.T) {
+		box, err := New(t.TempDir(), 1, 0)
+		require.NoError(t, err)
+
+		require.NoError(t, box.Append([]*model.Metrics{counter("PollCount", 3)}))
+		sending := box.Peek()
+
+		require.NoError(t, box.Append([]*model.Metrics{counter("PollCount", 2)}))
+		require.NoError(t, box.Append([]*model.Metrics{counter("PollCount", 1)}))
+		require.Equal(t, 2, box.Len(), "the second entry is merged into the third")
+		require.NoError(t, box.Remove(sending.ID))
+
+		entry := box.Peek()
+		assert.Equal(t, int64(3), *entry.Metrics[0].Delta, "the in-flight counters are not counted twice")
+	})
+
+	t.Run("Age cap", func(t *testing.T) {
+		box, err := New(t.TempDir(), 0, time.Hour)
+		require.NoError(t, err)
+
+		now := time.Now()
+		box.now = func() time.Time { return now.Add(-2 * time.Hour) }
+		require.NoError(t, box.Append([]*model.Metrics{counter("PollCount", 1)}))
+		box.now = func() time.Time { return now }
+		require.NoError(t, box.Append([]*model.Metrics{gauge("Alloc", 1)}))
+
+		entry := box.Peek()
+		require.NotNil(t, entry)
+		assert.Equal(t, 1, box.Len())
+		assert.Len(t, entry.Metrics, 2)
+	})
+
+	t.Run("Skips corrupted and temporary files", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000001.json"), []byte("{"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000002.json.tmp"), []byte("{}"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000003.json"), []byte(`{"metrics":[]}`), 0o644))
+
+		box, err := New(dir, 0, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 1, box.Len())
+		assert.Equal(t, 1, box.Dropped())
+
+		require.NoError(t, box.Append([]*model.Metrics{gauge("a", 1)}))
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, files, 2)
+		assert.Equal(t, "00000000000000000004.json", files[1].Name())
+	})
+}
@@ -0,0 +1,55 @@
+package proto
+
+import (
+	"github.com/Heidric/metrics.git/internal/model"
+)
+
+func FromModel(m *model.Metrics) *Metric {
+	metric := &Metric{
+		Id:     m.ID,
+		Type:   m.MType,
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Labels: m.Labels,
+	}
+
+	if m.Histogram != nil {
+		metric.Histogram = &Histogram{Sum: m.Histogram.Sum, Count: m.Histogram.Count}
+		for _, b := range m.Histogram.Buckets {
+			metric.Histogram.Buckets = append(metric.Histogram.Buckets, &Bucket{UpperBound: b.UpperBound, Count: b.Count})
+		}
+	}
+	if m.Summary != nil {
+		metric.Summary = &Summary{Sum: m.Summary.Sum, Count: m.Summary.Count}
+		for _, q := range m.Summary.Quantiles {
+			metric.Summary.Quantiles = append(metric.Summary.Quantiles, &Quantile{Quantile: q.Quantile, Value: q.Value})
+		}
+	}
+
+	return metric
+}
+
+func ToModel(m *Metric) *model.Metrics {
+	metric := &model.Metrics{
+		ID:     m.GetId(),
+		MType:  m.GetType(),
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Labels: m.GetLabels(),
+	}
+
+	if h := m.GetHistogram(); h != nil {
+		metric.Histogram = &model.Histogram{Sum: h.GetSum(), Count: h.GetCount()}
+		for _, b := range h.GetBuckets() {
+			metric.Histogram.Buckets = append(metric.Histogram.Buckets, model.Bucket{UpperBound: b.GetUpperBound(), Count: b.GetCount()})
+		}
+	}
+	if s := m.GetSummary(); s != nil {
+		metric.Summary = &model.Summary{Sum: s.GetSum(), Count: s.GetCount()}
+		for _, q := range s.GetQuantiles() {
+			metric.Summary.Quantiles = append(metric.Summary.Quantiles, model.Quantile{Quantile: q.GetQuantile(), Value: q.GetValue()})
+		}
+	}
+
+	return metric
+}
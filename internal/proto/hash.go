@@ -0,0 +1,18 @@
+package proto
+
+import (
+	"github.com/Heidric/metrics.git/internal/crypto"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// HashMetadataKey carries the HMAC of a message, the gRPC counterpart of the
+// HashSHA256 HTTP header.
+const HashMetadataKey = "hashsha256"
+
+func MessageHash(msg protobuf.Message, key string) (string, error) {
+	data, err := protobuf.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return crypto.HashSHA256(data, key), nil
+}
@@ -0,0 +1,256 @@
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MaxDecodedSize caps the uncompressed size of a single write request.
+const MaxDecodedSize = 32 << 20
+
+// staleNaN is the bit pattern Prometheus uses to mark a series as stale.
+const staleNaN uint64 = 0x7ff0000000000002
+
+const metricNameLabel = "__name__"
+
+var errWireType = errors.New("unexpected wire type")
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Decode decompresses and parses a snappy-compressed prompb.WriteRequest.
+// Only the fields needed here are read: metadata, exemplars and native
+// histograms are skipped.
+func Decode(compressed []byte) ([]TimeSeries, error) {
+	size, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy payload: %w", err)
+	}
+	if size > MaxDecodedSize {
+		return nil, fmt.Errorf("decoded payload of %d bytes exceeds the %d byte limit", size, MaxDecodedSize)
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy payload: %w", err)
+	}
+
+	var series []TimeSeries
+	err = consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		if typ != protowire.BytesType {
+			return errWireType
+		}
+		ts, err := decodeTimeSeries(b)
+		if err != nil {
+			return err
+		}
+		series = append(series, ts)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid write request: %w", err)
+	}
+	return series, nil
+}
+
+func decodeTimeSeries(b []byte) (TimeSeries, error) {
+	var ts TimeSeries
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte, _ uint64) error {
+		switch num {
+		case 1:
+			if typ != protowire.BytesType {
+				return errWireType
+			}
+			var l Label
+			err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte, _ uint64) error {
+				if num != 1 && num != 2 {
+					return nil
+				}
+				if typ != protowire.BytesType {
+					return errWireType
+				}
+				if num == 1 {
+					l.Name = string(b)
+				} else {
+					l.Value = string(b)
+				}
+				return nil
+			})
+			ts.Labels = append(ts.Labels, l)
+			return err
+		case 2:
+			if typ != protowire.BytesType {
+				return errWireType
+			}
+			var s Sample
+			err := consumeFields(b, func(num protowire.Number, typ protowire.Type, _ []byte, v uint64) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					s.Value = math.Float64frombits(v)
+				case num == 2 && typ == protowire.VarintType:
+					s.Timestamp = int64(v)
+				case num == 1 || num == 2:
+					return errWireType
+				}
+				return nil
+			})
+			ts.Samples = append(ts.Samples, s)
+			return err
+		default:
+			return nil
+		}
+	})
+	return ts, err
+}
+
+// Encode is the inverse of Decode and produces a snappy-compressed
+// prompb.WriteRequest.
+func Encode(series []TimeSeries) []byte {
+	var data []byte
+	for _, ts := range series {
+		var tsData []byte
+		for _, l := range ts.Labels {
+			var lData []byte
+			lData = protowire.AppendTag(lData, 1, protowire.BytesType)
+			lData = protowire.AppendString(lData, l.Name)
+			lData = protowire.AppendTag(lData, 2, protowire.BytesType)
+			lData = protowire.AppendString(lData, l.Value)
+			tsData = protowire.AppendTag(tsData, 1, protowire.BytesType)
+			tsData = protowire.AppendBytes(tsData, lData)
+		}
+		for _, s := range ts.Samples {
+			var sData []byte
+			sData = protowire.AppendTag(sData, 1, protowire.Fixed64Type)
+			sData = protowire.AppendFixed64(sData, math.Float64bits(s.Value))
+			sData = protowire.AppendTag(sData, 2, protowire.VarintType)
+			sData = protowire.AppendVarint(sData, uint64(s.Timestamp))
+			tsData = protowire.AppendTag(tsData, 2, protowire.BytesType)
+			tsData = protowire.AppendBytes(tsData, sData)
+		}
+		data = protowire.AppendTag(data, 1, protowire.BytesType)
+		data = protowire.AppendBytes(data, tsData)
+	}
+	return snappy.Encode(nil, data)
+}
+
+// consumeFields calls fn for every field in b with the payload of length
+// delimited fields or the raw value of scalar ones.
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, payload []byte, value uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var payload []byte
+		var value uint64
+		switch typ {
+		case protowire.BytesType:
+			payload, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			value = uint64(v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(num, typ, payload, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Metrics keeps the latest finite sample of every series as a gauge. Series
+// without samples worth storing are skipped; series without a metric name or
+// with invalid label names are returned as errors.
+func Metrics(series []TimeSeries) ([]*model.Metrics, []error) {
+	type latest struct {
+		metric    *model.Metrics
+		timestamp int64
+	}
+	byKey := make(map[string]*latest)
+	var keys []string
+	var errs []error
+
+	for _, ts := range series {
+		m := &model.Metrics{MType: model.GaugeType}
+		for _, l := range ts.Labels {
+			if l.Name == metricNameLabel {
+				m.ID = l.Value
+				continue
+			}
+			if m.Labels == nil {
+				m.Labels = make(model.Labels, len(ts.Labels))
+			}
+			m.Labels[l.Name] = l.Value
+		}
+		if m.ID == "" {
+			errs = append(errs, fmt.Errorf("series %s has no metric name", m.Labels))
+			continue
+		}
+		if err := m.Labels.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("series %s: %w", m.Key(), err))
+			continue
+		}
+
+		found := false
+		var sample Sample
+		for _, s := range ts.Samples {
+			if math.Float64bits(s.Value) == staleNaN || math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+				continue
+			}
+			if !found || s.Timestamp >= sample.Timestamp {
+				sample, found = s, true
+			}
+		}
+		if !found {
+			continue
+		}
+
+		key := m.Key()
+		if prev, ok := byKey[key]; ok && prev.timestamp > sample.Timestamp {
+			continue
+		} else if !ok {
+			keys = append(keys, key)
+		}
+		value := sample.Value
+		m.Value = &value
+		byKey[key] = &latest{metric: m, timestamp: sample.Timestamp}
+	}
+
+	result := make([]*model.Metrics, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, byKey[key].metric)
+	}
+	return result, errs
+}
@@ -0,0 +1,81 @@
+package remotewrite
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestDecode(t *testing.T) {
+	series := []TimeSeries{
+		{
+			Labels:  []Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+			Samples: []Sample{{Value: 1, Timestamp: 1000}, {Value: 0, Timestamp: 2000}},
+		},
+		{Labels: []Label{{Name: "__name__", Value: "temp"}}},
+	}
+
+	got, err := Decode(Encode(series))
+	require.NoError(t, err)
+	assert.Equal(t, series, got)
+
+	t.Run("Unknown fields are skipped", func(t *testing.T) {
+		var data []byte
+		data = protowire.AppendTag(data, 3, protowire.BytesType)
+		data = protowire.AppendString(data, "metadata")
+		data = protowire.AppendTag(data, 7, protowire.VarintType)
+		data = protowire.AppendVarint(data, 42)
+
+		got, err := Decode(snappy.Encode(nil, data))
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("Invalid payloads", func(t *testing.T) {
+		_, err := Decode([]byte("not snappy"))
+		assert.Error(t, err)
+
+		_, err = Decode(snappy.Encode(nil, []byte{0x0a, 0x05, 0x01}))
+		assert.Error(t, err, "truncated message")
+
+		var data []byte
+		data = protowire.AppendTag(data, 1, protowire.VarintType)
+		data = protowire.AppendVarint(data, 1)
+		_, err = Decode(snappy.Encode(nil, data))
+		assert.Error(t, err, "time series with the wrong wire type")
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	metrics, errs := Metrics([]TimeSeries{
+		{
+			Labels:  []Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+			Samples: []Sample{{Value: 1, Timestamp: 2000}, {Value: 0, Timestamp: 1000}},
+		},
+		{
+			Labels:  []Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+			Samples: []Sample{{Value: 5, Timestamp: 1500}},
+		},
+		{
+			Labels:  []Label{{Name: "__name__", Value: "stale"}},
+			Samples: []Sample{{Value: math.Float64frombits(staleNaN), Timestamp: 3000}, {Value: 2, Timestamp: 1000}},
+		},
+		{Labels: []Label{{Name: "__name__", Value: "gone"}}, Samples: []Sample{{Value: math.Inf(1)}}},
+		{Labels: []Label{{Name: "job", Value: "node"}}, Samples: []Sample{{Value: 1}}},
+		{Labels: []Label{{Name: "__name__", Value: "bad"}, {Name: "bad-label", Value: "x"}}, Samples: []Sample{{Value: 1}}},
+	})
+
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "up", metrics[0].ID)
+	assert.Equal(t, model.GaugeType, metrics[0].MType)
+	assert.Equal(t, model.Labels{"job": "node"}, metrics[0].Labels)
+	assert.Equal(t, 1.0, *metrics[0].Value, "the latest sample wins across series entries")
+	assert.Equal(t, "stale", metrics[1].ID)
+	assert.Equal(t, 2.0, *metrics[1].Value, "stale markers are ignored")
+	assert.Len(t, errs, 2)
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/audit"
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/Heidric/metrics.git/internal/logger"
+	"github.com/Heidric/metrics.git/internal/model"
+	pb "github.com/Heidric/metrics.git/internal/proto"
+	"github.com/Heidric/metrics.git/internal/server/middleware"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+type GRPCServer struct {
+	pb.UnimplementedMetricsServer
+
+	Srv       *grpc.Server
+	addr      string
+	hashKey   string
+	hashGrace bool
+	trusted   []netip.Prefix
+	timeout   time.Duration
+	metrics   Metrics
+}
+
+func NewGRPCServer(addr string, hashKey string, metrics Metrics, opts ...grpc.ServerOption) *GRPCServer {
+	s := &GRPCServer{
+		addr:    addr,
+		hashKey: hashKey,
+		metrics: metrics,
+	}
+	opts = append(opts, grpc.ChainUnaryInterceptor(s.clientIPInterceptor, s.timeoutInterceptor, s.subnetInterceptor, s.hashInterceptor))
+	s.Srv = grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(s.Srv, s)
+	return s
+}
+
+func (s *GRPCServer) SetHashGrace(grace bool) {
+	s.hashGrace = grace
+}
+
+func (s *GRPCServer) SetTrustedSubnets(subnets []netip.Prefix) {
+	s.trusted = subnets
+}
+
+func (s *GRPCServer) SetRequestTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
+
+func (s *GRPCServer) Run(ctx context.Context, runner *errgroup.Group) error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	logger.Log.Info().Msg("gRPC server started.")
+
+	runner.Go(func() error {
+		if err := s.Srv.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			return err
+		}
+		return nil
+	})
+	return nil
+}
+
+func (s *GRPCServer) Shutdown(ctx context.Context) error {
+	logger.Log.Info().Msg("gRPC server stopped.")
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		s.Srv.Stop()
+	}
+	return nil
+}
+
+func (s *GRPCServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	metrics := make([]*model.Metrics, 0, len(req.GetMetrics()))
+	for _, m := range req.GetMetrics() {
+		metrics = append(metrics, pb.ToModel(m))
+	}
+
+	if err := s.metrics.UpdateMetricsBatch(ctx, metrics); err != nil {
+		return nil, grpcError(err, "Batch update failed")
+	}
+	return &pb.UpdateMetricsResponse{}, nil
+}
+
+func (s *GRPCServer) GetMetric(ctx context.Context, req *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	metric := &model.Metrics{
+		ID:     req.GetId(),
+		MType:  req.GetType(),
+		Labels: req.GetLabels(),
+	}
+
+	if err := s.metrics.GetMetricJSON(ctx, metric); err != nil {
+		return nil, grpcError(err, "Failed to get metric")
+	}
+	return &pb.GetMetricResponse{Metric: pb.FromModel(metric)}, nil
+}
+
+func (s *GRPCServer) ListMetrics(ctx context.Context, req *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	metrics, err := s.metrics.ListAllMetrics(ctx)
+	if err != nil {
+		return nil, grpcError(err, "Failed to list metrics")
+	}
+
+	resp := &pb.ListMetricsResponse{Metrics: make([]*pb.Metric, 0, len(metrics))}
+	for _, m := range metrics {
+		resp.Metrics = append(resp.Metrics, pb.FromModel(m))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) timeoutInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if s.timeout <= 0 {
+		return handler(ctx, req)
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return handler(ctx, req)
+}
+
+func (s *GRPCServer) subnetInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if len(s.trusted) == 0 || info.FullMethod != pb.Metrics_UpdateMetrics_FullMethodName {
+		return handler(ctx, req)
+	}
+
+	addr := clientIP(ctx)
+	if !middleware.IPAllowed(s.trusted, addr) {
+		logger.Log.Warn().Str("ip", addr).Str("method", info.FullMethod).Msg("Request from untrusted address rejected")
+		return nil, status.Error(codes.PermissionDenied, "untrusted address")
+	}
+	return handler(ctx, req)
+}
+
+// clientIP mirrors middleware.ClientIP: the x-real-ip metadata wins over the peer address.
+// clientIPInterceptor stores the caller's address for the audit log, as
+// ClientIPMiddleware does for HTTP.
+func (s *GRPCServer) clientIPInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(audit.WithClientIP(ctx, clientIP(ctx)), req)
+}
+
+func clientIP(ctx context.Context) string {
+	if values := metadata.ValueFromIncomingContext(ctx, strings.ToLower(middleware.RealIPHeader)); len(values) > 0 {
+		return values[0]
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func (s *GRPCServer) hashInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if s.hashKey == "" {
+		return handler(ctx, req)
+	}
+
+	msg, ok := req.(protobuf.Message)
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	expected, err := pb.MessageHash(msg, s.hashKey)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to hash request")
+	}
+	var got string
+	if values := metadata.ValueFromIncomingContext(ctx, pb.HashMetadataKey); len(values) > 0 {
+		got = values[0]
+	}
+	if !hmac.Equal([]byte(got), []byte(expected)) {
+		if !s.hashGrace {
+			return nil, status.Error(codes.Unauthenticated, "invalid request hash")
+		}
+		logger.Log.Warn().Str("method", info.FullMethod).Msg("Request hash mismatch, accepted in grace mode")
+	}
+
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+
+	if out, ok := resp.(protobuf.Message); ok {
+		if hash, err := pb.MessageHash(out, s.hashKey); err == nil {
+			grpc.SetHeader(ctx, metadata.Pairs(pb.HashMetadataKey, hash))
+		}
+	}
+	return resp, nil
+}
+
+func grpcError(err error, msg string) error {
+	switch {
+	case errors.Is(err, customerrors.ErrInvalidType),
+		errors.Is(err, customerrors.ErrInvalidValue),
+		errors.Is(err, customerrors.ErrInvalidLabels):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, customerrors.ErrKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		logger.Log.Error().Msgf("%s: %v", msg, err)
+		return status.Error(codes.Internal, msg)
+	}
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/Heidric/metrics.git/internal/logger"
+	"github.com/Heidric/metrics.git/internal/model"
+	pb "github.com/Heidric/metrics.git/internal/proto"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestGRPCClient(t *testing.T, metrics *mockMetrics, hashKey string) pb.MetricsClient {
+	t.Helper()
+	l := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &l
+
+	listener := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer("bufconn", hashKey, metrics)
+	go srv.Srv.Serve(listener)
+	t.Cleanup(srv.Srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewMetricsClient(conn)
+}
+
+func TestGRPCServer(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("UpdateMetrics", func(t *testing.T) {
+		var got []*model.Metrics
+		client := newTestGRPCClient(t, &mockMetrics{
+			updateMetricsBatchFn: func(metrics []*model.Metrics) error {
+				got = metrics
+				return nil
+			},
+		}, "")
+
+		value := 1.5
+		delta := int64(3)
+		_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+			pb.FromModel(&model.Metrics{ID: "Alloc", MType: model.GaugeType, Value: &value, Labels: model.Labels{"host": "a"}}),
+			pb.FromModel(&model.Metrics{ID: "PollCount", MType: model.CounterType, Delta: &delta}),
+		}})
+		require.NoError(t, err)
+
+		require.Len(t, got, 2)
+		assert.Equal(t, 1.5, *got[0].Value)
+		assert.Equal(t, model.Labels{"host": "a"}, got[0].Labels)
+		assert.Nil(t, got[0].Delta)
+		assert.Equal(t, int64(3), *got[1].Delta)
+	})
+
+	t.Run("UpdateMetrics error", func(t *testing.T) {
+		client := newTestGRPCClient(t, &mockMetrics{
+			updateMetricsBatchFn: func(metrics []*model.Metrics) error {
+				return customerrors.ErrNotConnected
+			},
+		}, "")
+
+		_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{})
+		assert.Equal(t, codes.Internal, status.Code(err))
+	})
+
+	t.Run("GetMetric", func(t *testing.T) {
+		client := newTestGRPCClient(t, &mockMetrics{
+			getMetricJSONFn: func(metric *model.Metrics) error {
+				if metric.ID != "Alloc" {
+					return customerrors.ErrKeyNotFound
+				}
+				v := 42.0
+				metric.Value = &v
+				return nil
+			},
+		}, "")
+
+		resp, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Alloc", Type: model.GaugeType})
+		require.NoError(t, err)
+		assert.Equal(t, 42.0, resp.GetMetric().GetValue())
+
+		_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "missing", Type: model.GaugeType})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("ListMetrics", func(t *testing.T) {
+		client := newTestGRPCClient(t, &mockMetrics{
+			listAllMetricsFn: func() ([]*model.Metrics, error) {
+				return []*model.Metrics{{
+					ID:        "latency",
+					MType:     model.HistogramType,
+					Histogram: &model.Histogram{Buckets: []model.Bucket{{UpperBound: 1, Count: 2}}, Sum: 1, Count: 2},
+				}}, nil
+			},
+		}, "")
+
+		resp, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{})
+		require.NoError(t, err)
+		require.Len(t, resp.GetMetrics(), 1)
+		assert.Equal(t, &model.Histogram{Buckets: []model.Bucket{{UpperBound: 1, Count: 2}}, Sum: 1, Count: 2},
+			pb.ToModel(resp.GetMetrics()[0]).Histogram)
+	})
+
+	t.Run("Hash metadata", func(t *testing.T) {
+		client := newTestGRPCClient(t, &mockMetrics{
+			getMetricJSONFn: func(metric *model.Metrics) error {
+				v := 1.0
+				metric.Value = &v
+				return nil
+			},
+		}, "secret")
+
+		req := &pb.GetMetricRequest{Id: "Alloc", Type: model.GaugeType}
+		hash, err := pb.MessageHash(req, "secret")
+		require.NoError(t, err)
+
+		var header metadata.MD
+		resp, err := client.GetMetric(metadata.AppendToOutgoingContext(ctx, pb.HashMetadataKey, hash), req, grpc.Header(&header))
+		require.NoError(t, err)
+
+		expected, err := pb.MessageHash(resp, "secret")
+		require.NoError(t, err)
+		assert.Equal(t, []string{expected}, header.Get(pb.HashMetadataKey))
+
+		_, err = client.GetMetric(metadata.AppendToOutgoingContext(ctx, pb.HashMetadataKey, "bad"), req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		_, err = client.GetMetric(ctx, req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), "missing hash must be rejected")
+	})
+
+	t.Run("Hash grace mode", func(t *testing.T) {
+		l := zerolog.New(nil).Level(zerolog.Disabled)
+		logger.Log = &l
+
+		listener := bufconn.Listen(1 << 20)
+		srv := NewGRPCServer("bufconn", "secret", &mockMetrics{})
+		srv.SetHashGrace(true)
+		go srv.Srv.Serve(listener)
+		defer srv.Srv.Stop()
+
+		conn, err := grpc.NewClient("passthrough:///bufconn",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = pb.NewMetricsClient(conn).UpdateMetrics(ctx, &pb.UpdateMetricsRequest{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Trusted subnet", func(t *testing.T) {
+		l := zerolog.New(nil).Level(zerolog.Disabled)
+		logger.Log = &l
+
+		listener := bufconn.Listen(1 << 20)
+		srv := NewGRPCServer("bufconn", "", &mockMetrics{
+			getMetricJSONFn: func(metric *model.Metrics) error {
+				v := 1.0
+				metric.Value = &v
+				return nil
+			},
+		})
+		srv.SetTrustedSubnets([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+		go srv.Srv.Serve(listener)
+		defer srv.Srv.Stop()
+
+		conn, err := grpc.NewClient("passthrough:///bufconn",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		require.NoError(t, err)
+		defer conn.Close()
+		client := pb.NewMetricsClient(conn)
+
+		_, err = client.UpdateMetrics(metadata.AppendToOutgoingContext(ctx, "x-real-ip", "10.0.0.5"), &pb.UpdateMetricsRequest{})
+		assert.NoError(t, err)
+
+		_, err = client.UpdateMetrics(metadata.AppendToOutgoingContext(ctx, "x-real-ip", "192.168.0.5"), &pb.UpdateMetricsRequest{})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err), "bufconn peer is not in the trusted subnet")
+
+		_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Alloc", Type: model.GaugeType})
+		assert.NoError(t, err)
+	})
+}
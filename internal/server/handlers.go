@@ -4,13 +4,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Heidric/metrics.git/internal/customerrors"
 	"github.com/Heidric/metrics.git/internal/logger"
 	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/Heidric/metrics.git/internal/server/middleware"
 	"github.com/go-chi/chi"
 )
 
@@ -18,7 +23,7 @@ func (s *Server) getMetricHandler(w http.ResponseWriter, r *http.Request) {
 	metricType := chi.URLParam(r, "metricType")
 	metricName := chi.URLParam(r, "metricName")
 
-	metric, err := s.metrics.GetMetric(metricType, metricName)
+	metric, err := s.metrics.GetMetric(r.Context(), metricType, metricName)
 	if err != nil {
 		if err == customerrors.ErrKeyNotFound {
 			customerrors.WriteError(w, http.StatusNotFound, "")
@@ -47,9 +52,9 @@ func (s *Server) updateMetricHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
 	switch metricType {
 	case model.GaugeType:
-		err = s.metrics.UpdateGauge(name, value)
+		err = s.metrics.UpdateGauge(r.Context(), name, value)
 	case model.CounterType:
-		err = s.metrics.UpdateCounter(name, value)
+		err = s.metrics.UpdateCounter(r.Context(), name, value)
 	default:
 		customerrors.WriteError(w, http.StatusBadRequest, "Invalid metric type")
 		return
@@ -69,7 +74,7 @@ func (s *Server) updateMetricHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) listMetricsHandler(w http.ResponseWriter, r *http.Request) {
-	allMetrics := s.metrics.ListMetrics()
+	allMetrics := s.metrics.ListMetrics(r.Context())
 
 	w.Header().Set("Content-Type", "text/html")
 	w.WriteHeader(http.StatusOK)
@@ -80,7 +85,7 @@ func (s *Server) listMetricsHandler(w http.ResponseWriter, r *http.Request) {
              <tr><th>Name</th><th>Value</th></tr>`
 
 	for name, value := range allMetrics {
-		html += fmt.Sprintf("<tr><td>%s</td><td>%s</td></tr>", name, value)
+		html += fmt.Sprintf("<tr><td>%s</td><td>%s</td></tr>", template.HTMLEscapeString(name), template.HTMLEscapeString(value))
 	}
 
 	html += "</table></body></html>"
@@ -92,9 +97,9 @@ func (s *Server) notFoundHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) updateMetricJSONHandler(w http.ResponseWriter, r *http.Request) {
-	bodyBytes, err := io.ReadAll(r.Body)
+	bodyBytes, err := io.ReadAll(http.MaxBytesReader(w, r.Body, middleware.MaxBodySize))
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		middleware.WriteBodyError(w, err)
 		return
 	}
 
@@ -104,10 +109,11 @@ func (s *Server) updateMetricJSONHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := s.metrics.UpdateMetricJSON(&metric); err != nil {
+	if err := s.metrics.UpdateMetricJSON(r.Context(), &metric); err != nil {
 		switch {
 		case errors.Is(err, customerrors.ErrInvalidType),
-			errors.Is(err, customerrors.ErrInvalidValue):
+			errors.Is(err, customerrors.ErrInvalidValue),
+			errors.Is(err, customerrors.ErrInvalidLabels):
 			customerrors.WriteError(w, http.StatusBadRequest, err.Error())
 		case errors.Is(err, customerrors.ErrKeyNotFound):
 			customerrors.WriteError(w, http.StatusNotFound, "")
@@ -130,9 +136,10 @@ func (s *Server) getMetricJSONHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.metrics.GetMetricJSON(&metric); err != nil {
+	if err := s.metrics.GetMetricJSON(r.Context(), &metric); err != nil {
 		switch {
-		case errors.Is(err, customerrors.ErrInvalidType):
+		case errors.Is(err, customerrors.ErrInvalidType),
+			errors.Is(err, customerrors.ErrInvalidLabels):
 			customerrors.WriteError(w, http.StatusBadRequest, err.Error())
 		case errors.Is(err, customerrors.ErrKeyNotFound):
 			customerrors.WriteError(w, http.StatusNotFound, "")
@@ -149,9 +156,9 @@ func (s *Server) getMetricJSONHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) updateMetricsBatchHandler(w http.ResponseWriter, r *http.Request) {
-	bodyBytes, err := io.ReadAll(r.Body)
+	bodyBytes, err := io.ReadAll(http.MaxBytesReader(w, r.Body, middleware.MaxBodySize))
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		middleware.WriteBodyError(w, err)
 		return
 	}
 
@@ -161,7 +168,7 @@ func (s *Server) updateMetricsBatchHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if err := s.metrics.UpdateMetricsBatch(metrics); err != nil {
+	if err := s.metrics.UpdateMetricsBatch(r.Context(), metrics); err != nil {
 		http.Error(w, "Batch update failed", http.StatusBadRequest)
 		return
 	}
@@ -177,3 +184,83 @@ func (s *Server) pingHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+func (s *Server) listRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if s.alerts == nil {
+		customerrors.WriteError(w, http.StatusNotFound, "Alerting is not configured")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s.alerts.Rules())
+}
+
+func (s *Server) queryRangeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	to := time.Now()
+	if v := query.Get("to"); v != "" {
+		t, err := parseQueryTime(v)
+		if err != nil {
+			customerrors.WriteError(w, http.StatusBadRequest, "Invalid to parameter")
+			return
+		}
+		to = t
+	}
+
+	from := to.Add(-time.Hour)
+	if v := query.Get("from"); v != "" {
+		t, err := parseQueryTime(v)
+		if err != nil {
+			customerrors.WriteError(w, http.StatusBadRequest, "Invalid from parameter")
+			return
+		}
+		from = t
+	}
+
+	var step time.Duration
+	if v := query.Get("step"); v != "" {
+		d, err := parseQueryStep(v)
+		if err != nil {
+			customerrors.WriteError(w, http.StatusBadRequest, "Invalid step parameter")
+			return
+		}
+		step = d
+	}
+
+	series, err := s.metrics.QueryRange(r.Context(), query.Get("name"), query.Get("type"), from, to, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, customerrors.ErrInvalidType),
+			errors.Is(err, customerrors.ErrInvalidValue):
+			customerrors.WriteError(w, http.StatusBadRequest, err.Error())
+		default:
+			logger.Log.Error().Msgf("Failed to query range [%s]: %v", query.Get("name"), err)
+			customerrors.WriteError(w, http.StatusInternalServerError, "")
+		}
+		return
+	}
+	if series == nil {
+		series = []*model.Series{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(series)
+}
+
+func parseQueryTime(v string) (time.Time, error) {
+	if sec, err := strconv.ParseFloat(v, 64); err == nil {
+		whole, frac := math.Modf(sec)
+		return time.Unix(int64(whole), int64(frac*1e9)), nil
+	}
+	return time.Parse(time.RFC3339Nano, v)
+}
+
+func parseQueryStep(v string) (time.Duration, error) {
+	if sec, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(sec * float64(time.Second)), nil
+	}
+	return time.ParseDuration(v)
+}
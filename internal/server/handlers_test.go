@@ -8,12 +8,16 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/Heidric/metrics.git/internal/alerting"
 	"github.com/Heidric/metrics.git/internal/crypto"
 	"github.com/Heidric/metrics.git/internal/customerrors"
 	"github.com/Heidric/metrics.git/internal/logger"
 	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/Heidric/metrics.git/internal/server/middleware"
 	"github.com/go-chi/chi"
 	"github.com/rs/zerolog"
 )
@@ -23,27 +27,51 @@ type mockMetrics struct {
 	updateCounterFn      func(name, value string) error
 	getMetricFn          func(metricType, metricName string) (string, error)
 	listMetricsFn        func() map[string]string
+	listAllMetricsFn     func() ([]*model.Metrics, error)
 	updateMetricJSONFn   func(metric *model.Metrics) error
 	getMetricJSONFn      func(metric *model.Metrics) error
 	updateMetricsBatchFn func(metrics []*model.Metrics) error
+	queryRangeFn         func(name, mtype string, from, to time.Time, step time.Duration) ([]*model.Series, error)
 }
 
-func (m *mockMetrics) Ping(ctx context.Context) error         { return nil }
-func (m *mockMetrics) UpdateGauge(name, value string) error   { return m.updateGaugeFn(name, value) }
-func (m *mockMetrics) UpdateCounter(name, value string) error { return m.updateCounterFn(name, value) }
-func (m *mockMetrics) GetMetric(t, n string) (string, error)  { return m.getMetricFn(t, n) }
-func (m *mockMetrics) ListMetrics() map[string]string         { return m.listMetricsFn() }
-func (m *mockMetrics) UpdateMetricJSON(metric *model.Metrics) error {
+func (m *mockMetrics) Ping(ctx context.Context) error { return nil }
+func (m *mockMetrics) UpdateGauge(ctx context.Context, name, value string) error {
+	return m.updateGaugeFn(name, value)
+}
+func (m *mockMetrics) UpdateCounter(ctx context.Context, name, value string) error {
+	return m.updateCounterFn(name, value)
+}
+func (m *mockMetrics) GetMetric(ctx context.Context, t, n string) (string, error) {
+	return m.getMetricFn(t, n)
+}
+func (m *mockMetrics) ListMetrics(ctx context.Context) map[string]string { return m.listMetricsFn() }
+func (m *mockMetrics) ListAllMetrics(ctx context.Context) ([]*model.Metrics, error) {
+	if m.listAllMetricsFn != nil {
+		return m.listAllMetricsFn()
+	}
+	return nil, nil
+}
+func (m *mockMetrics) UpdateMetricJSON(ctx context.Context, metric *model.Metrics) error {
 	return m.updateMetricJSONFn(metric)
 }
-func (m *mockMetrics) GetMetricJSON(metric *model.Metrics) error { return m.getMetricJSONFn(metric) }
-func (m *mockMetrics) UpdateMetricsBatch(metrics []*model.Metrics) error {
+func (m *mockMetrics) GetMetricJSON(ctx context.Context, metric *model.Metrics) error {
+	return m.getMetricJSONFn(metric)
+}
+func (m *mockMetrics) UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error {
 	if m.updateMetricsBatchFn != nil {
 		return m.updateMetricsBatchFn(metrics)
 	}
 	return nil
 }
 
+type mockAlerts []alerting.RuleStatus
+
+func (m mockAlerts) Rules() []alerting.RuleStatus { return m }
+
+func (m *mockMetrics) QueryRange(ctx context.Context, name, mtype string, from, to time.Time, step time.Duration) ([]*model.Series, error) {
+	return m.queryRangeFn(name, mtype, from, to, step)
+}
+
 func newTestServer(t *testing.T, metrics *mockMetrics, hashKey string) (*chi.Mux, *Server) {
 	t.Helper()
 	l := zerolog.New(nil).Level(zerolog.Disabled)
@@ -235,4 +263,236 @@ func TestHandlers(t *testing.T) {
 			t.Errorf("expected 200 with valid hash, got %d", w.Code)
 		}
 	})
+	t.Run("Write requests with invalid hash", func(t *testing.T) {
+		key := "secret"
+		updated := 0
+		mock := &mockMetrics{
+			updateMetricJSONFn:   func(metric *model.Metrics) error { updated++; return nil },
+			updateMetricsBatchFn: func(metrics []*model.Metrics) error { updated++; return nil },
+			updateGaugeFn:        func(name, value string) error { updated++; return nil },
+		}
+		r, srv := newTestServer(t, mock, key)
+
+		raw := []byte(`[{"id":"a","type":"counter","delta":5}]`)
+		compressed, err := gzipCompress(t, raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		newRequests := func() []*http.Request {
+			single := httptest.NewRequest("POST", "/update/", bytes.NewReader([]byte(`{"id":"x","type":"gauge","value":1}`)))
+			single.Header.Set("HashSHA256", crypto.HashSHA256([]byte(`{"id":"x","type":"gauge","value":2}`), key))
+
+			batch := httptest.NewRequest("POST", "/updates/", bytes.NewReader(compressed))
+			batch.Header.Set("Content-Encoding", "gzip")
+			batch.Header.Set("HashSHA256", crypto.HashSHA256(compressed, key))
+
+			path := httptest.NewRequest("POST", "/update/gauge/x/1", nil)
+			return []*http.Request{single, batch, path}
+		}
+
+		for _, req := range newRequests() {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400 on hash mismatch, got %d", req.URL.Path, w.Code)
+			}
+		}
+		if updated != 0 {
+			t.Errorf("expected no updates on hash mismatch, got %d", updated)
+		}
+
+		srv.SetHashGrace(true)
+		for _, req := range newRequests() {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: expected 200 in grace mode, got %d", req.URL.Path, w.Code)
+			}
+		}
+		if updated != 3 {
+			t.Errorf("expected 3 updates in grace mode, got %d", updated)
+		}
+	})
+
+	t.Run("UpdateMetricsBatch encrypted", func(t *testing.T) {
+		key := "secret"
+		var got []*model.Metrics
+		mock := &mockMetrics{
+			updateMetricsBatchFn: func(metrics []*model.Metrics) error { got = metrics; return nil },
+		}
+		r, srv := newTestServer(t, mock, key)
+
+		privatePEM, publicPEM, err := crypto.GenerateKeyPair(2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		if err := os.WriteFile(dir+"/private.pem", privatePEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dir+"/public.pem", publicPEM, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		priv, err := crypto.LoadPrivateKey(dir + "/private.pem")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := crypto.LoadPublicKey(dir + "/public.pem")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		raw := []byte(`[{"id":"a","type":"counter","delta":5}]`)
+		compressed, err := gzipCompress(t, raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed, err := crypto.Encrypt(pub, compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		newRequest := func() *http.Request {
+			req := httptest.NewRequest("POST", "/updates/", bytes.NewReader(sealed))
+			req.Header.Set("Content-Encoding", "gzip")
+			req.Header.Set(crypto.EncryptionHeader, crypto.EncryptionScheme)
+			req.Header.Set("HashSHA256", crypto.HashSHA256(raw, key))
+			return req
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, newRequest())
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 without private key, got %d", w.Code)
+		}
+
+		srv.SetPrivateKey(priv)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, newRequest())
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if len(got) != 1 || got[0].ID != "a" || *got[0].Delta != 5 {
+			t.Errorf("unexpected metrics: %+v", got)
+		}
+
+		plain := httptest.NewRequest("POST", "/updates/", bytes.NewReader(raw))
+		plain.Header.Set("HashSHA256", crypto.HashSHA256(raw, key))
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, plain)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for unencrypted write with a private key, got %d", w.Code)
+		}
+
+		oversized := httptest.NewRequest("POST", "/updates/", bytes.NewReader(make([]byte, middleware.MaxBodySize+1)))
+		oversized.Header.Set(crypto.EncryptionHeader, crypto.EncryptionScheme)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, oversized)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected 413 for oversized encrypted body, got %d", w.Code)
+		}
+
+		tampered := append([]byte(nil), sealed...)
+		tampered[len(tampered)-1] ^= 0xff
+		req := httptest.NewRequest("POST", "/updates/", bytes.NewReader(tampered))
+		req.Header.Set(crypto.EncryptionHeader, crypto.EncryptionScheme)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for tampered payload, got %d", w.Code)
+		}
+	})
+
+	t.Run("ListRules not configured", func(t *testing.T) {
+		r, _ := newTestServer(t, &mockMetrics{}, "")
+
+		req := httptest.NewRequest("GET", "/api/v1/rules", nil)
+		w := httptest.NewRecorder()
+
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected 404 without alerting, got %d", w.Code)
+		}
+	})
+
+	t.Run("ListRules success", func(t *testing.T) {
+		r, srv := newTestServer(t, &mockMetrics{}, "")
+		srv.SetAlerts(mockAlerts{{
+			Rule:  alerting.Rule{Name: "HighHeap", Metric: "HeapAlloc", MType: model.GaugeType, Op: ">"},
+			State: alerting.StateFiring,
+		}})
+
+		req := httptest.NewRequest("GET", "/api/v1/rules", nil)
+		w := httptest.NewRecorder()
+
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var rules []alerting.RuleStatus
+		if err := json.NewDecoder(w.Body).Decode(&rules); err != nil {
+			t.Fatalf("failed to decode rules: %v", err)
+		}
+		if len(rules) != 1 || rules[0].Name != "HighHeap" || rules[0].State != alerting.StateFiring {
+			t.Errorf("unexpected rules: %+v", rules)
+		}
+	})
+	t.Run("QueryRange success", func(t *testing.T) {
+		mock := &mockMetrics{
+			queryRangeFn: func(name, mtype string, from, to time.Time, step time.Duration) ([]*model.Series, error) {
+				if name != "Alloc" || mtype != model.GaugeType {
+					t.Errorf("unexpected query: %s %s", name, mtype)
+				}
+				if !from.Equal(time.Unix(1000, 0)) || !to.Equal(time.Unix(2000, 500000000)) || step != 30*time.Second {
+					t.Errorf("unexpected range: %v %v %v", from, to, step)
+				}
+				return []*model.Series{{
+					ID: name, MType: mtype,
+					Points: []model.Point{{Timestamp: from, Value: 1}},
+				}}, nil
+			},
+		}
+		r, _ := newTestServer(t, mock, "")
+
+		req := httptest.NewRequest("GET", "/api/v1/query_range?name=Alloc&type=gauge&from=1000&to=2000.5&step=30s", nil)
+		w := httptest.NewRecorder()
+
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var series []model.Series
+		if err := json.NewDecoder(w.Body).Decode(&series); err != nil {
+			t.Fatalf("failed to decode series: %v", err)
+		}
+		if len(series) != 1 || len(series[0].Points) != 1 {
+			t.Errorf("unexpected series: %+v", series)
+		}
+	})
+
+	t.Run("QueryRange invalid parameters", func(t *testing.T) {
+		mock := &mockMetrics{
+			queryRangeFn: func(name, mtype string, from, to time.Time, step time.Duration) ([]*model.Series, error) {
+				return nil, customerrors.ErrInvalidType
+			},
+		}
+		r, _ := newTestServer(t, mock, "")
+
+		for _, path := range []string{
+			"/api/v1/query_range?name=Alloc&type=gauge&from=yesterday",
+			"/api/v1/query_range?name=Alloc&type=gauge&step=often",
+			"/api/v1/query_range?name=Alloc&type=text",
+		} {
+			req := httptest.NewRequest("GET", path, nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", path, w.Code)
+			}
+		}
+	})
 }
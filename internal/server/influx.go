@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/Heidric/metrics.git/internal/influx"
+	"github.com/Heidric/metrics.git/internal/logger"
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/Heidric/metrics.git/internal/server/middleware"
+)
+
+type influxWriteError struct {
+	customerrors.CommonError
+	Lines []influx.LineError `json:"lines"`
+}
+
+// influxWriteHandler accepts InfluxDB line protocol. Valid lines are stored
+// even when others are rejected, in which case the response lists the
+// rejected lines with a 400 status, mirroring InfluxDB partial writes.
+func (s *Server) influxWriteHandler(w http.ResponseWriter, r *http.Request) {
+	precision, err := influx.ParsePrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		customerrors.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, middleware.MaxBodySize))
+	if err != nil {
+		middleware.WriteBodyError(w, err)
+		return
+	}
+
+	points, lineErrors := influx.Parse(body, precision)
+	counters := s.influxCounters.Begin()
+	defer counters.Discard()
+	var metrics []*model.Metrics
+	for _, point := range points {
+		metrics = append(metrics, point.Metrics(s.influxRules, counters)...)
+	}
+
+	if len(metrics) > 0 {
+		if err := s.metrics.UpdateMetricsBatch(r.Context(), metrics); err != nil {
+			logger.Log.Error().Msgf("Failed to write influx points: %v", err)
+			customerrors.WriteError(w, http.StatusInternalServerError, "")
+			return
+		}
+		counters.Commit()
+	}
+
+	if len(lineErrors) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(influxWriteError{
+			CommonError: customerrors.CommonError{
+				Title:   "Partial write",
+				Status:  http.StatusBadRequest,
+				Details: fmt.Sprintf("%d lines rejected", len(lineErrors)),
+			},
+			Lines: lineErrors,
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
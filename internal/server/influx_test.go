@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/influx"
+	"github.com/Heidric/metrics.git/internal/logger"
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/Heidric/metrics.git/internal/server/middleware"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxWriteHandler(t *testing.T) {
+	testLogger := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &testLogger
+
+	var calls int
+	var got []*model.Metrics
+	var writeErr error
+	srv := NewServer(":8080", "", &mockMetrics{
+		updateMetricsBatchFn: func(metrics []*model.Metrics) error {
+			calls++
+			got = metrics
+			return writeErr
+		},
+	})
+	rules, err := influx.ParseRules([]string{"*_total=counter"})
+	require.NoError(t, err)
+	srv.SetInfluxRules(rules)
+
+	t.Run("Valid body", func(t *testing.T) {
+		calls, got = 0, nil
+		body := "http,host=a requests_total=5i,latency=0.25 1700000000\nmem used=10i"
+		rec := httptest.NewRecorder()
+		srv.Srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v2/write?precision=s", strings.NewReader(body)))
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, 1, calls, "all lines go out in one batch")
+		require.Len(t, got, 3)
+		assert.Equal(t, model.CounterType, got[0].MType)
+		assert.Equal(t, model.GaugeType, got[1].MType)
+		assert.Equal(t, model.GaugeType, got[2].MType)
+	})
+
+	t.Run("Cumulative counters", func(t *testing.T) {
+		write := func(v string) (int, int64) {
+			rec := httptest.NewRecorder()
+			srv.Srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/write", strings.NewReader("http,host=a requests_total="+v)))
+			return rec.Code, *got[0].Delta
+		}
+
+		code, delta := write("8i")
+		assert.Equal(t, http.StatusNoContent, code)
+		assert.Equal(t, int64(3), delta, "only the increase since the previous write is stored")
+
+		writeErr = errors.New("storage down")
+		code, _ = write("12i")
+		assert.Equal(t, http.StatusInternalServerError, code)
+
+		writeErr = nil
+		_, delta = write("12i")
+		assert.Equal(t, int64(4), delta, "a failed write does not advance the counter")
+	})
+
+	t.Run("Oversized body", func(t *testing.T) {
+		calls = 0
+		rec := httptest.NewRecorder()
+		srv.Srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/write", strings.NewReader(strings.Repeat("a", middleware.MaxBodySize+1))))
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Zero(t, calls)
+	})
+
+	t.Run("Partial write", func(t *testing.T) {
+		calls, got = 0, nil
+		rec := httptest.NewRecorder()
+		srv.Srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/write", strings.NewReader("cpu usage=1\ncpu usage=\nmem\n")))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, 1, calls)
+		require.Len(t, got, 1)
+
+		var resp influxWriteError
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		require.Len(t, resp.Lines, 2)
+		assert.Equal(t, 2, resp.Lines[0].Line)
+		assert.Equal(t, 3, resp.Lines[1].Line)
+	})
+
+	t.Run("Invalid precision", func(t *testing.T) {
+		calls = 0
+		rec := httptest.NewRecorder()
+		srv.Srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/write?precision=d", strings.NewReader("cpu usage=1")))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Zero(t, calls)
+	})
+}
+
+func TestInfluxWriteHandlerConcurrent(t *testing.T) {
+	testLogger := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &testLogger
+
+	var mu sync.Mutex
+	var deltas []int64
+	blocked, release := make(chan struct{}), make(chan struct{})
+	srv := NewServer(":8080", "", &mockMetrics{
+		updateMetricsBatchFn: func(metrics []*model.Metrics) error {
+			if *metrics[0].Delta == 5 {
+				close(blocked)
+				<-release
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			deltas = append(deltas, *metrics[0].Delta)
+			return nil
+		},
+	})
+	rules, err := influx.ParseRules([]string{"*_total=counter"})
+	require.NoError(t, err)
+	srv.SetInfluxRules(rules)
+
+	write := func(v string) int {
+		rec := httptest.NewRecorder()
+		srv.Srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/write", strings.NewReader("jobs done_total="+v)))
+		return rec.Code
+	}
+	require.Equal(t, http.StatusNoContent, write("10i"))
+
+	done := make(chan int)
+	go func() { done <- write("15i") }()
+	<-blocked
+
+	second := make(chan int)
+	go func() { second <- write("17i") }()
+	select {
+	case code := <-second:
+		assert.Equal(t, http.StatusNoContent, code)
+	case <-time.After(time.Second):
+		t.Fatal("a write blocked in storage holds up other writes")
+	}
+
+	close(release)
+	assert.Equal(t, http.StatusNoContent, <-done)
+	assert.Equal(t, []int64{0, 2, 5}, deltas)
+}
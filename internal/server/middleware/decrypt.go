@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/Heidric/metrics.git/internal/crypto"
+	"github.com/Heidric/metrics.git/internal/customerrors"
+)
+
+// MaxBodySize caps the request bodies read in full before being processed.
+const MaxBodySize = 32 << 20
+
+type decryptedKey struct{}
+
+// DecryptMiddleware opens bodies sealed with crypto.Encrypt. Requests without
+// the encryption header pass through unchanged; RequireEncryptionMiddleware
+// rejects them on routes that must be encrypted.
+func DecryptMiddleware(privateKey func() *rsa.PrivateKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme := r.Header.Get(crypto.EncryptionHeader)
+			if scheme == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := privateKey()
+			if key == nil || scheme != crypto.EncryptionScheme {
+				customerrors.WriteError(w, http.StatusBadRequest, "Unsupported encryption")
+				return
+			}
+
+			data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+			if err != nil {
+				WriteBodyError(w, err)
+				return
+			}
+			plain, err := crypto.Decrypt(key, data)
+			if err != nil {
+				customerrors.WriteError(w, http.StatusBadRequest, "Failed to decrypt request body")
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(plain))
+			r.ContentLength = int64(len(plain))
+			r.Header.Del(crypto.EncryptionHeader)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), decryptedKey{}, true)))
+		})
+	}
+}
+
+// RequireEncryptionMiddleware rejects requests that DecryptMiddleware did not
+// open while a private key is configured.
+func RequireEncryptionMiddleware(privateKey func() *rsa.PrivateKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if privateKey() != nil && r.Context().Value(decryptedKey{}) == nil {
+				customerrors.WriteError(w, http.StatusBadRequest, "Encryption required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WriteBodyError reports a failed body read, with 413 when the body exceeded
+// its size limit.
+func WriteBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		customerrors.WriteError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+	customerrors.WriteError(w, http.StatusBadRequest, "Failed to read request body")
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/Heidric/metrics.git/internal/audit"
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/Heidric/metrics.git/internal/logger"
+)
+
+const RealIPHeader = "X-Real-IP"
+
+func ParseSubnets(cidrs []string) ([]netip.Prefix, error) {
+	subnets := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, prefix.Masked())
+	}
+	return subnets, nil
+}
+
+// IPAllowed reports whether addr belongs to one of subnets. An empty list allows everything.
+func IPAllowed(subnets []netip.Prefix, addr string) bool {
+	if len(subnets) == 0 {
+		return true
+	}
+
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the X-Real-IP header, or the peer address when the header is absent.
+func ClientIP(r *http.Request) string {
+	if addr := r.Header.Get(RealIPHeader); addr != "" {
+		return addr
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// ClientIPMiddleware records the client address in the request context for auditing.
+func ClientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(audit.WithClientIP(r.Context(), ClientIP(r))))
+	})
+}
+
+// TrustedSubnetMiddleware rejects requests whose X-Real-IP, or the peer address
+// when the header is absent, is outside the trusted subnets.
+func TrustedSubnetMiddleware(subnets func() []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed := subnets()
+			if len(allowed) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			addr := ClientIP(r)
+			if !IPAllowed(allowed, addr) {
+				logger.Log.Warn().Str("ip", addr).Str("path", r.URL.Path).Msg("Request from untrusted address rejected")
+				customerrors.WriteError(w, http.StatusForbidden, "")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
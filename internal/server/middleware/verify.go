@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"io"
+	"net/http"
+
+	"github.com/Heidric/metrics.git/internal/crypto"
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/Heidric/metrics.git/internal/logger"
+)
+
+// VerifyHashMiddleware checks the HashSHA256 header against the request body.
+// It must run after the body has been decompressed. When grace reports true,
+// mismatches are only logged.
+func VerifyHashMiddleware(key string, grace func() bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// The body may already be decompressed, so it is capped here too.
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+			if err != nil {
+				WriteBodyError(w, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			got := r.Header.Get("HashSHA256")
+			expected := crypto.HashSHA256(body, key)
+			if hmac.Equal([]byte(got), []byte(expected)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reason := "hash mismatch"
+			if got == "" {
+				reason = "missing hash"
+			}
+			if grace != nil && grace() {
+				logger.Log.Warn().Str("path", r.URL.Path).Msgf("Request %s, accepted in grace mode", reason)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger.Log.Warn().Str("path", r.URL.Path).Msgf("Request rejected: %s", reason)
+			customerrors.WriteError(w, http.StatusBadRequest, "Invalid HashSHA256")
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/Heidric/metrics.git/internal/logger"
+	"github.com/Heidric/metrics.git/internal/otlp"
+	"github.com/Heidric/metrics.git/internal/server/middleware"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// otlpMetricsHandler implements the OTLP/HTTP metrics export endpoint. The
+// response uses the request's encoding and carries a partial success when
+// some data points could not be mapped.
+func (s *Server) otlpMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != contentTypeProtobuf && contentType != contentTypeJSON {
+		customerrors.WriteError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/x-protobuf or application/json")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, middleware.MaxBodySize))
+	if err != nil {
+		middleware.WriteBodyError(w, err)
+		return
+	}
+
+	req := &colmetricspb.ExportMetricsServiceRequest{}
+	if contentType == contentTypeJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, req)
+	} else {
+		err = proto.Unmarshal(body, req)
+	}
+	if err != nil {
+		customerrors.WriteError(w, http.StatusBadRequest, "Invalid OTLP payload")
+		return
+	}
+
+	counters := s.otlpCounters.Begin()
+	defer counters.Discard()
+	metrics, partial := otlp.Convert(req, counters)
+	if len(metrics) > 0 {
+		if err := s.metrics.UpdateMetricsBatch(r.Context(), metrics); err != nil {
+			logger.Log.Error().Msgf("Failed to write OTLP metrics: %v", err)
+			customerrors.WriteError(w, http.StatusServiceUnavailable, "")
+			return
+		}
+		counters.Commit()
+	}
+
+	resp := &colmetricspb.ExportMetricsServiceResponse{PartialSuccess: partial}
+	var out []byte
+	if contentType == contentTypeJSON {
+		out, err = protojson.Marshal(resp)
+	} else {
+		out, err = proto.Marshal(resp)
+	}
+	if err != nil {
+		customerrors.WriteError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
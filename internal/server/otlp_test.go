@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/logger"
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPMetricsHandler(t *testing.T) {
+	testLogger := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &testLogger
+
+	var got []*model.Metrics
+	var writeErr error
+	srv := NewServer(":8080", "", &mockMetrics{
+		updateMetricsBatchFn: func(metrics []*model.Metrics) error {
+			got = append(got, metrics...)
+			return writeErr
+		},
+	})
+
+	t.Run("Protobuf", func(t *testing.T) {
+		got = nil
+		body, err := proto.Marshal(&colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{{
+				Name: "temperature",
+				Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+					{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 21.5}},
+				}}},
+			}}}},
+		}}})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		rec := httptest.NewRecorder()
+		srv.Srv.Handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/x-protobuf", rec.Header().Get("Content-Type"))
+		var resp colmetricspb.ExportMetricsServiceResponse
+		require.NoError(t, proto.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Nil(t, resp.PartialSuccess)
+
+		require.Len(t, got, 1)
+		assert.Equal(t, 21.5, *got[0].Value)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		got = nil
+		body := `{"resourceMetrics":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"api"}}]},
+			"scopeMetrics":[{"metrics":[
+				{"name":"requests","sum":{"aggregationTemporality":2,"isMonotonic":true,
+					"dataPoints":[{"startTimeUnixNano":"1","asInt":"12"}]}},
+				{"name":"latency","histogram":{"dataPoints":[{"count":"1"}]}}
+			]}]}]}`
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Srv.Handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"rejectedDataPoints":"1"`)
+
+		require.Len(t, got, 1)
+		assert.Equal(t, model.CounterType, got[0].MType)
+		assert.Equal(t, int64(0), *got[0].Delta, "the first cumulative point is the baseline")
+		assert.Equal(t, model.Labels{"service_name": "api"}, got[0].Labels)
+	})
+
+	t.Run("Cumulative state advances only after a write", func(t *testing.T) {
+		export := func(v string) (int, int64) {
+			got = nil
+			body := `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"jobs","sum":{"aggregationTemporality":2,"isMonotonic":true,
+				"dataPoints":[{"startTimeUnixNano":"1","asInt":"` + v + `"}]}}]}]}]}`
+			req := httptest.NewRequest(http.MethodPost, "/v1/metrics", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			srv.Srv.Handler.ServeHTTP(rec, req)
+			require.Len(t, got, 1)
+			return rec.Code, *got[0].Delta
+		}
+
+		export("10")
+		writeErr = errors.New("storage down")
+		code, _ := export("15")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+
+		writeErr = nil
+		code, delta := export("15")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, int64(5), delta, "the failed export is counted again")
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "text/plain")
+		rec := httptest.NewRecorder()
+		srv.Srv.Handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+		req = httptest.NewRequest(http.MethodPost, "/v1/metrics", strings.NewReader("not json"))
+		req.Header.Set("Content-Type", "application/json")
+		rec = httptest.NewRecorder()
+		srv.Srv.Handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestOTLPMetricsHandlerConcurrent(t *testing.T) {
+	testLogger := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &testLogger
+
+	var mu sync.Mutex
+	var deltas []int64
+	blocked, release := make(chan struct{}), make(chan struct{})
+	srv := NewServer(":8080", "", &mockMetrics{
+		updateMetricsBatchFn: func(metrics []*model.Metrics) error {
+			if *metrics[0].Delta == 5 {
+				close(blocked)
+				<-release
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			deltas = append(deltas, *metrics[0].Delta)
+			return nil
+		},
+	})
+
+	export := func(v string) int {
+		body := `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"jobs","sum":{"aggregationTemporality":2,"isMonotonic":true,
+			"dataPoints":[{"startTimeUnixNano":"1","asInt":"` + v + `"}]}}]}]}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Srv.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	require.Equal(t, http.StatusOK, export("10"))
+
+	done := make(chan int)
+	go func() { done <- export("15") }()
+	<-blocked
+
+	second := make(chan int)
+	go func() { second <- export("17") }()
+	select {
+	case code := <-second:
+		assert.Equal(t, http.StatusOK, code)
+	case <-time.After(time.Second):
+		t.Fatal("an export blocked in storage holds up other exports")
+	}
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, []int64{0, 2, 5}, deltas)
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/Heidric/metrics.git/internal/logger"
+	"github.com/Heidric/metrics.git/internal/model"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+func (s *Server) prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	metrics, err := s.metrics.ListAllMetrics(r.Context())
+	if err != nil {
+		logger.Log.Error().Msgf("Failed to list metrics: %v", err)
+		customerrors.WriteError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	w.WriteHeader(http.StatusOK)
+
+	bw := bufio.NewWriter(w)
+	writePrometheus(bw, metrics)
+	bw.Flush()
+}
+
+// writePrometheus writes one block per metric family. Distinct IDs can
+// sanitise to the same family name (a.b and a_b), so samples are grouped by
+// family first and series that collide within a family are written once.
+// Label names are sanitised before series are compared for the same reason.
+func writePrometheus(w *bufio.Writer, metrics []*model.Metrics) {
+	types := make(map[string]string, len(metrics))
+	families := make(map[string][]*model.Metrics, len(metrics))
+	var names []string
+	seen := make(map[string]bool, len(metrics))
+
+	for _, m := range metrics {
+		switch m.MType {
+		case model.GaugeType:
+			if m.Value == nil {
+				continue
+			}
+		case model.CounterType:
+			if m.Delta == nil {
+				continue
+			}
+		case model.HistogramType:
+			if m.Histogram == nil {
+				continue
+			}
+		case model.SummaryType:
+			if m.Summary == nil {
+				continue
+			}
+		default:
+			continue
+		}
+
+		name := sanitizePrometheusName(m.ID)
+		for t, ok := types[name]; ok && t != m.MType; t, ok = types[name] {
+			name += "_" + m.MType
+		}
+		if _, ok := types[name]; !ok {
+			types[name] = m.MType
+			names = append(names, name)
+		}
+
+		sanitized := *m
+		sanitized.Labels = sanitizePrometheusLabels(m.Labels)
+		key := model.SeriesKey(name, sanitized.Labels)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		families[name] = append(families[name], &sanitized)
+	}
+
+	for _, name := range names {
+		w.WriteString("# TYPE " + name + " " + types[name] + "\n")
+		for _, m := range families[name] {
+			writePrometheusMetric(w, name, m)
+		}
+	}
+}
+
+func writePrometheusMetric(w *bufio.Writer, name string, m *model.Metrics) {
+	switch m.MType {
+	case model.GaugeType:
+		writePrometheusSample(w, name, m.Labels, "", "", formatPrometheusFloat(*m.Value))
+	case model.CounterType:
+		writePrometheusSample(w, name, m.Labels, "", "", strconv.FormatInt(*m.Delta, 10))
+	case model.HistogramType:
+		h := m.Histogram
+		for _, b := range h.Buckets {
+			writePrometheusSample(w, name+"_bucket", m.Labels, "le", formatPrometheusFloat(b.UpperBound), strconv.FormatUint(b.Count, 10))
+		}
+		writePrometheusSample(w, name+"_bucket", m.Labels, "le", "+Inf", strconv.FormatUint(h.Count, 10))
+		writePrometheusSample(w, name+"_sum", m.Labels, "", "", formatPrometheusFloat(h.Sum))
+		writePrometheusSample(w, name+"_count", m.Labels, "", "", strconv.FormatUint(h.Count, 10))
+	case model.SummaryType:
+		sum := m.Summary
+		for _, q := range sum.Quantiles {
+			writePrometheusSample(w, name, m.Labels, "quantile", formatPrometheusFloat(q.Quantile), formatPrometheusFloat(q.Value))
+		}
+		writePrometheusSample(w, name+"_sum", m.Labels, "", "", formatPrometheusFloat(sum.Sum))
+		writePrometheusSample(w, name+"_count", m.Labels, "", "", strconv.FormatUint(sum.Count, 10))
+	}
+}
+
+func writePrometheusSample(w *bufio.Writer, name string, labels model.Labels, extraName, extraValue, value string) {
+	w.WriteString(name)
+	writePrometheusLabels(w, labels, extraName, extraValue)
+	w.WriteString(" " + value + "\n")
+}
+
+func writePrometheusLabels(w *bufio.Writer, labels model.Labels, extraName, extraValue string) {
+	if len(labels) == 0 && extraName == "" {
+		return
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != extraName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	w.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		writePrometheusLabel(w, name, labels[name])
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			w.WriteByte(',')
+		}
+		writePrometheusLabel(w, extraName, extraValue)
+	}
+	w.WriteByte('}')
+}
+
+func writePrometheusLabel(w *bufio.Writer, name, value string) {
+	w.WriteString(name)
+	w.WriteString(`="`)
+	w.WriteString(labelValueReplacer.Replace(value))
+	w.WriteByte('"')
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sanitizePrometheusLabels maps label names onto [a-zA-Z_][a-zA-Z0-9_]*.
+// When several names sanitise to the same one, the first in sorted order wins
+// and the others are dropped, since a duplicate label fails the whole scrape.
+func sanitizePrometheusLabels(labels model.Labels) model.Labels {
+	if len(labels) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make(model.Labels, len(labels))
+	for _, name := range names {
+		sanitized := sanitizePrometheusLabelName(name)
+		if _, ok := result[sanitized]; ok {
+			continue
+		}
+		result[sanitized] = labels[name]
+	}
+	return result
+}
+
+// sanitizePrometheusLabelName differs from metric names in that ':' is not
+// allowed.
+func sanitizePrometheusLabelName(name string) string {
+	return strings.ReplaceAll(sanitizePrometheusName(name), ":", "_")
+}
+
+func sanitizePrometheusName(name string) string {
+	if name == "" {
+		return "_"
+	}
+
+	var b strings.Builder
+	b.Grow(len(name) + 1)
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func formatPrometheusFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
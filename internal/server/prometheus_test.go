@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizePrometheusName(t *testing.T) {
+	tests := map[string]string{
+		"HeapAlloc":       "HeapAlloc",
+		"http.requests":   "http_requests",
+		"cpu-usage%":      "cpu_usage_",
+		"9lives":          "_9lives",
+		"ns:metric_name1": "ns:metric_name1",
+		"":                "_",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, sanitizePrometheusName(in), in)
+	}
+}
+
+func TestWritePrometheusDistributions(t *testing.T) {
+	var buf strings.Builder
+	w := bufio.NewWriter(&buf)
+	writePrometheus(w, []*model.Metrics{
+		{ID: "latency", MType: model.HistogramType, Labels: model.Labels{"host": "a"}, Histogram: &model.Histogram{
+			Buckets: []model.Bucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 3}},
+			Sum:     2.5,
+			Count:   4,
+		}},
+		{ID: "rpc", MType: model.SummaryType, Summary: &model.Summary{
+			Quantiles: []model.Quantile{{Quantile: 0.5, Value: 0.2}, {Quantile: 0.99, Value: 1}},
+			Sum:       7,
+			Count:     10,
+		}},
+	})
+	require.NoError(t, w.Flush())
+
+	assert.Equal(t, "# TYPE latency histogram\n"+
+		"latency_bucket{host=\"a\",le=\"0.1\"} 1\n"+
+		"latency_bucket{host=\"a\",le=\"1\"} 3\n"+
+		"latency_bucket{host=\"a\",le=\"+Inf\"} 4\n"+
+		"latency_sum{host=\"a\"} 2.5\n"+
+		"latency_count{host=\"a\"} 4\n"+
+		"# TYPE rpc summary\n"+
+		"rpc{quantile=\"0.5\"} 0.2\n"+
+		"rpc{quantile=\"0.99\"} 1\n"+
+		"rpc_sum 7\n"+
+		"rpc_count 10\n", buf.String())
+}
+
+func TestWritePrometheusCollidingNames(t *testing.T) {
+	var buf strings.Builder
+	w := bufio.NewWriter(&buf)
+	writePrometheus(w, []*model.Metrics{
+		{ID: "a.b", MType: model.GaugeType, Value: ptrFloat64(1), Labels: model.Labels{"host": "x"}},
+		{ID: "other", MType: model.GaugeType, Value: ptrFloat64(2)},
+		{ID: "a_b", MType: model.GaugeType, Value: ptrFloat64(3), Labels: model.Labels{"host": "y"}},
+		{ID: "a-b", MType: model.GaugeType, Value: ptrFloat64(4), Labels: model.Labels{"host": "x"}},
+	})
+	require.NoError(t, w.Flush())
+
+	assert.Equal(t, "# TYPE a_b gauge\n"+
+		"a_b{host=\"x\"} 1\n"+
+		"a_b{host=\"y\"} 3\n"+
+		"# TYPE other gauge\n"+
+		"other 2\n", buf.String())
+}
+
+func TestWritePrometheusLabelNames(t *testing.T) {
+	var buf strings.Builder
+	w := bufio.NewWriter(&buf)
+	writePrometheus(w, []*model.Metrics{
+		{ID: "cpu", MType: model.GaugeType, Value: ptrFloat64(1), Labels: model.Labels{"ns:host": "a", "zone": "b"}},
+		{ID: "mem", MType: model.GaugeType, Value: ptrFloat64(2), Labels: model.Labels{"a.b": "x", "a_b": "y"}},
+		{ID: "mem", MType: model.GaugeType, Value: ptrFloat64(3), Labels: model.Labels{"a-b": "x"}},
+	})
+	require.NoError(t, w.Flush())
+
+	assert.Equal(t, "# TYPE cpu gauge\n"+
+		"cpu{ns_host=\"a\",zone=\"b\"} 1\n"+
+		"# TYPE mem gauge\n"+
+		"mem{a_b=\"x\"} 2\n", buf.String(), "':' is replaced and colliding label names are written once")
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	mock := &mockMetrics{
+		listAllMetricsFn: func() ([]*model.Metrics, error) {
+			return []*model.Metrics{
+				{ID: "Alloc", MType: model.GaugeType, Value: ptrFloat64(1.5e9)},
+				{ID: "PollCount", MType: model.CounterType, Delta: ptrInt64(42)},
+				{ID: "PollCount", MType: model.CounterType, Delta: ptrInt64(7), Labels: model.Labels{"host": "a\"b", "agent_id": "1"}},
+				{ID: "cpu.util", MType: model.GaugeType, Value: ptrFloat64(0.25)},
+				{ID: "dup", MType: model.CounterType, Delta: ptrInt64(1)},
+				{ID: "dup", MType: model.GaugeType, Value: ptrFloat64(2)},
+			}, nil
+		},
+	}
+	r, _ := newTestServer(t, mock, "")
+
+	t.Run("Plain text", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, prometheusContentType, w.Header().Get("Content-Type"))
+		assert.Equal(t, "# TYPE Alloc gauge\n"+
+			"Alloc 1.5e+09\n"+
+			"# TYPE PollCount counter\n"+
+			"PollCount 42\n"+
+			"PollCount{agent_id=\"1\",host=\"a\\\"b\"} 7\n"+
+			"# TYPE cpu_util gauge\n"+
+			"cpu_util 0.25\n"+
+			"# TYPE dup counter\n"+
+			"dup 1\n"+
+			"# TYPE dup_gauge gauge\n"+
+			"dup_gauge 2\n", w.Body.String())
+	})
+
+	t.Run("Gzip", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		gz, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "PollCount 42\n")
+	})
+
+	t.Run("Storage error", func(t *testing.T) {
+		r, _ := newTestServer(t, &mockMetrics{
+			listAllMetricsFn: func() ([]*model.Metrics, error) { return nil, errors.New("boom") },
+		}, "")
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
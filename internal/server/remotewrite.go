@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/Heidric/metrics.git/internal/logger"
+	"github.com/Heidric/metrics.git/internal/remotewrite"
+	"github.com/Heidric/metrics.git/internal/server/middleware"
+)
+
+// remoteWriteHandler receives Prometheus remote_write requests. Prometheus
+// retries 5xx responses and drops the batch on 4xx, so only storage failures
+// are reported as server errors.
+func (s *Server) remoteWriteHandler(w http.ResponseWriter, r *http.Request) {
+	if enc := r.Header.Get("Content-Encoding"); enc != "" && enc != "snappy" {
+		customerrors.WriteError(w, http.StatusUnsupportedMediaType, "Content-Encoding must be snappy")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, middleware.MaxBodySize))
+	if err != nil {
+		middleware.WriteBodyError(w, err)
+		return
+	}
+
+	series, err := remotewrite.Decode(body)
+	if err != nil {
+		customerrors.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	metrics, errs := remotewrite.Metrics(series)
+	if len(metrics) > 0 {
+		if err := s.metrics.UpdateMetricsBatch(r.Context(), metrics); err != nil {
+			logger.Log.Error().Msgf("Failed to store remote write samples: %v", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, customerrors.ErrNotConnected) ||
+				errors.Is(err, context.DeadlineExceeded) ||
+				errors.Is(err, context.Canceled) {
+				status = http.StatusServiceUnavailable
+			}
+			customerrors.WriteError(w, status, "")
+			return
+		}
+	}
+
+	if len(errs) > 0 {
+		customerrors.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%d series rejected, first: %v", len(errs), errs[0]))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
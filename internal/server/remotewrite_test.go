@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Heidric/metrics.git/internal/customerrors"
+	"github.com/Heidric/metrics.git/internal/logger"
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/Heidric/metrics.git/internal/remotewrite"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteWriteHandler(t *testing.T) {
+	testLogger := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &testLogger
+
+	var got []*model.Metrics
+	var storeErr error
+	srv := NewServer(":8080", "", &mockMetrics{
+		updateMetricsBatchFn: func(metrics []*model.Metrics) error {
+			got = metrics
+			return storeErr
+		},
+	})
+
+	write := func(body []byte, encoding string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Content-Encoding", encoding)
+		rec := httptest.NewRecorder()
+		srv.Srv.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	valid := remotewrite.Encode([]remotewrite.TimeSeries{{
+		Labels:  []remotewrite.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "a"}},
+		Samples: []remotewrite.Sample{{Value: 1, Timestamp: 1000}},
+	}})
+
+	tests := []struct {
+		name       string
+		body       []byte
+		encoding   string
+		storeErr   error
+		wantStatus int
+		wantStored int
+	}{
+		{name: "Success", body: valid, encoding: "snappy", wantStatus: http.StatusNoContent, wantStored: 1},
+		{name: "Unsupported encoding", body: valid, encoding: "zstd", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "Corrupt payload", body: []byte("garbage"), encoding: "snappy", wantStatus: http.StatusBadRequest},
+		{
+			name: "Invalid series are rejected permanently",
+			body: remotewrite.Encode([]remotewrite.TimeSeries{
+				{Labels: []remotewrite.Label{{Name: "__name__", Value: "up"}}, Samples: []remotewrite.Sample{{Value: 1}}},
+				{Labels: []remotewrite.Label{{Name: "job", Value: "x"}}, Samples: []remotewrite.Sample{{Value: 1}}},
+			}),
+			encoding:   "snappy",
+			wantStatus: http.StatusBadRequest,
+			wantStored: 1,
+		},
+		{name: "Unavailable storage is retryable", body: valid, encoding: "snappy", storeErr: customerrors.ErrNotConnected, wantStatus: http.StatusServiceUnavailable, wantStored: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, storeErr = nil, tt.storeErr
+			assert.Equal(t, tt.wantStatus, write(tt.body, tt.encoding))
+			require.Len(t, got, tt.wantStored)
+		})
+	}
+}
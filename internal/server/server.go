@@ -3,11 +3,17 @@ package server
 import (
 	"compress/gzip"
 	"context"
+	"crypto/rsa"
+	"crypto/tls"
 	"io"
 	"net/http"
+	"net/netip"
 	"strings"
 	"time"
 
+	"github.com/Heidric/metrics.git/internal/alerting"
+	"github.com/Heidric/metrics.git/internal/cumulative"
+	"github.com/Heidric/metrics.git/internal/influx"
 	"github.com/Heidric/metrics.git/internal/logger"
 	"github.com/Heidric/metrics.git/internal/model"
 	"github.com/Heidric/metrics.git/internal/server/middleware"
@@ -17,21 +23,35 @@ import (
 )
 
 type Metrics interface {
-	ListMetrics() map[string]string
-	GetMetric(metricType, metricName string) (string, error)
-	UpdateGauge(name, value string) error
-	UpdateCounter(name, value string) error
-	UpdateMetricJSON(metric *model.Metrics) error
-	GetMetricJSON(metric *model.Metrics) error
-	UpdateMetricsBatch(metrics []*model.Metrics) error
+	ListMetrics(ctx context.Context) map[string]string
+	ListAllMetrics(ctx context.Context) ([]*model.Metrics, error)
+	GetMetric(ctx context.Context, metricType, metricName string) (string, error)
+	UpdateGauge(ctx context.Context, name, value string) error
+	UpdateCounter(ctx context.Context, name, value string) error
+	UpdateMetricJSON(ctx context.Context, metric *model.Metrics) error
+	GetMetricJSON(ctx context.Context, metric *model.Metrics) error
+	UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error
+	QueryRange(ctx context.Context, name, mtype string, from, to time.Time, step time.Duration) ([]*model.Series, error)
 	Ping(ctx context.Context) error
 }
 
+type Alerts interface {
+	Rules() []alerting.RuleStatus
+}
+
 type Server struct {
-	Srv     *http.Server
-	hashKey string
-	metrics Metrics
-	logger  *zerolog.Logger
+	Srv            *http.Server
+	hashKey        string
+	hashGrace      bool
+	privateKey     *rsa.PrivateKey
+	trusted        []netip.Prefix
+	timeout        time.Duration
+	influxRules    []influx.Rule
+	influxCounters *cumulative.Tracker
+	otlpCounters   *cumulative.Tracker
+	metrics        Metrics
+	alerts         Alerts
+	logger         *zerolog.Logger
 }
 
 type gzipResponseWriter struct {
@@ -48,23 +68,46 @@ func NewServer(addr string, hashKey string, metrics Metrics) *Server {
 
 	r := chi.NewRouter()
 	s := &Server{
-		Srv:     &http.Server{Addr: addr, Handler: r},
-		hashKey: hashKey,
-		metrics: metrics,
-		logger:  &logger,
+		Srv:            &http.Server{Addr: addr, Handler: r},
+		hashKey:        hashKey,
+		metrics:        metrics,
+		influxCounters: cumulative.NewTracker(),
+		otlpCounters:   cumulative.NewTracker(),
+		logger:         &logger,
 	}
 
+	r.Use(middleware.DecryptMiddleware(s.decryptionKey))
 	r.Use(s.gzipMiddleware)
 	r.Use(s.loggingMiddleware)
+	r.Use(s.timeoutMiddleware)
 
 	r.Route("/", func(r chi.Router) {
 		r.Get("/", s.listMetricsHandler)
-		r.Post("/update/{metricType}/{metricName}/{metricValue}", s.updateMetricHandler)
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.TrustedSubnetMiddleware(s.trustedSubnets))
+			r.Use(middleware.RequireEncryptionMiddleware(s.decryptionKey))
+			r.Use(middleware.VerifyHashMiddleware(hashKey, s.hashGraceEnabled))
+			r.Use(middleware.ClientIPMiddleware)
+			r.Post("/update/{metricType}/{metricName}/{metricValue}", s.updateMetricHandler)
+			r.Post("/update/", s.updateMetricJSONHandler)
+			r.Post("/updates/", s.updateMetricsBatchHandler)
+		})
+		// Influx, OTLP and remote_write clients cannot sign bodies with
+		// HashSHA256, so these routes are only restricted by subnet.
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.TrustedSubnetMiddleware(s.trustedSubnets))
+			r.Use(middleware.ClientIPMiddleware)
+			r.Post("/api/v2/write", s.influxWriteHandler)
+			r.Post("/write", s.influxWriteHandler)
+			r.Post("/v1/metrics", s.otlpMetricsHandler)
+			r.Post("/api/v1/write", s.remoteWriteHandler)
+		})
 		r.Get("/value/{metricType}/{metricName}", s.getMetricHandler)
-		r.Post("/update/", s.updateMetricJSONHandler)
 		r.With(middleware.HashMiddleware(hashKey)).Post("/value/", s.getMetricJSONHandler)
-		r.Post("/updates/", s.updateMetricsBatchHandler)
 		r.Get("/ping", s.pingHandler)
+		r.Get("/metrics", s.prometheusHandler)
+		r.Get("/api/v1/rules", s.listRulesHandler)
+		r.Get("/api/v1/query_range", s.queryRangeHandler)
 	})
 
 	r.NotFound(s.notFoundHandler)
@@ -72,6 +115,49 @@ func NewServer(addr string, hashKey string, metrics Metrics) *Server {
 	return s
 }
 
+func (s *Server) SetAlerts(alerts Alerts) {
+	s.alerts = alerts
+}
+
+func (s *Server) SetHashGrace(grace bool) {
+	s.hashGrace = grace
+}
+
+func (s *Server) hashGraceEnabled() bool {
+	return s.hashGrace
+}
+
+func (s *Server) SetTrustedSubnets(subnets []netip.Prefix) {
+	s.trusted = subnets
+}
+
+func (s *Server) trustedSubnets() []netip.Prefix {
+	return s.trusted
+}
+
+// SetRequestTimeout bounds how long a single request may spend in the service
+// and storage layers. Zero disables the limit.
+func (s *Server) SetRequestTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
+
+// SetInfluxRules decides which integer line protocol fields become counters.
+func (s *Server) SetInfluxRules(rules []influx.Rule) {
+	s.influxRules = rules
+}
+
+func (s *Server) SetTLSConfig(cfg *tls.Config) {
+	s.Srv.TLSConfig = cfg
+}
+
+func (s *Server) SetPrivateKey(key *rsa.PrivateKey) {
+	s.privateKey = key
+}
+
+func (s *Server) decryptionKey() *rsa.PrivateKey {
+	return s.privateKey
+}
+
 func (s *Server) gzipMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
@@ -111,11 +197,29 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+func (s *Server) timeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func (s *Server) Run(ctx context.Context, runner *errgroup.Group) {
 	logger.Log.Info().Msg("Http server started.")
 
 	runner.Go(func() error {
-		if err := s.Srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if s.Srv.TLSConfig != nil {
+			err = s.Srv.ListenAndServeTLS("", "")
+		} else {
+			err = s.Srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			return err
 		}
 		return nil
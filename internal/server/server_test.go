@@ -1,16 +1,27 @@
 package server
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Heidric/metrics.git/internal/audit"
+	"github.com/Heidric/metrics.git/internal/crypto"
 	"github.com/Heidric/metrics.git/internal/db"
 	"github.com/Heidric/metrics.git/internal/logger"
+	"github.com/Heidric/metrics.git/internal/remotewrite"
+	"github.com/Heidric/metrics.git/internal/server/middleware"
 	"github.com/Heidric/metrics.git/internal/services"
 	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServerRoutes(t *testing.T) {
@@ -48,6 +59,7 @@ func TestServerRoutes(t *testing.T) {
 		name          string
 		method        string
 		path          string
+		hash          string
 		wantStatus    int
 		wantHeader    string
 		wantHeaderVal string
@@ -56,14 +68,22 @@ func TestServerRoutes(t *testing.T) {
 			name:       "Update gauge - valid",
 			method:     "POST",
 			path:       "/update/gauge/temp/42.5",
+			hash:       crypto.HashSHA256(nil, hashKey),
 			wantStatus: http.StatusOK,
 		},
 		{
 			name:       "Update counter - valid",
 			method:     "POST",
 			path:       "/update/counter/requests/1",
+			hash:       crypto.HashSHA256(nil, hashKey),
 			wantStatus: http.StatusOK,
 		},
+		{
+			name:       "Update gauge - missing hash",
+			method:     "POST",
+			path:       "/update/gauge/temp/1",
+			wantStatus: http.StatusBadRequest,
+		},
 		{
 			name:          "List metrics",
 			method:        "GET",
@@ -100,6 +120,9 @@ func TestServerRoutes(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
+			if tt.hash != "" {
+				req.Header.Set("HashSHA256", tt.hash)
+			}
 
 			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
@@ -119,3 +142,207 @@ func TestServerRoutes(t *testing.T) {
 		})
 	}
 }
+
+func TestTrustedSubnet(t *testing.T) {
+	testLogger := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &testLogger
+
+	_, err := middleware.ParseSubnets([]string{"10.0.0.0/33"})
+	require.Error(t, err)
+
+	subnets, err := middleware.ParseSubnets([]string{"10.0.0.1/8", " fd00::/8"})
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("10.0.0.0/8"), subnets[0])
+	assert.True(t, middleware.IPAllowed(nil, "not-an-ip"))
+	assert.True(t, middleware.IPAllowed(subnets, "fd00::1"))
+	assert.False(t, middleware.IPAllowed(subnets, "not-an-ip"))
+
+	storage := db.NewStore("", 0)
+	defer storage.Close()
+	srv := NewServer(":8080", "", services.NewMetricsService(storage))
+	srv.SetTrustedSubnets(subnets)
+	testServer := httptest.NewServer(srv.Srv.Handler)
+	defer testServer.Close()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		realIP     string
+		wantStatus int
+	}{
+		{name: "Trusted address", method: http.MethodPost, path: "/update/gauge/temp/1", realIP: "10.1.2.3", wantStatus: http.StatusOK},
+		{name: "Untrusted address", method: http.MethodPost, path: "/update/gauge/temp/1", realIP: "192.168.1.1", wantStatus: http.StatusForbidden},
+		{name: "Peer address fallback", method: http.MethodPost, path: "/updates/", wantStatus: http.StatusForbidden},
+		{name: "Reads are not restricted", method: http.MethodGet, path: "/value/gauge/temp", realIP: "192.168.1.1", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, testServer.URL+tt.path, nil)
+			require.NoError(t, err)
+			if tt.realIP != "" {
+				req.Header.Set(middleware.RealIPHeader, tt.realIP)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	testLogger := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &testLogger
+
+	srv := NewServer(":8080", "", &mockMetrics{})
+	var remaining time.Duration
+	var hasDeadline bool
+	srv.GetRouter().Get("/deadline", func(w http.ResponseWriter, r *http.Request) {
+		var deadline time.Time
+		deadline, hasDeadline = r.Context().Deadline()
+		remaining = time.Until(deadline)
+	})
+
+	srv.Srv.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/deadline", nil))
+	assert.False(t, hasDeadline, "no timeout is applied by default")
+
+	srv.SetRequestTimeout(time.Second)
+	srv.Srv.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/deadline", nil))
+	require.True(t, hasDeadline)
+	assert.InDelta(t, time.Second, remaining, float64(100*time.Millisecond))
+}
+
+func TestIngestionRoutesSkipHash(t *testing.T) {
+	testLogger := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &testLogger
+
+	storage := db.NewStore("", 0)
+	defer storage.Close()
+	srv := NewServer(":8080", "hash-key", services.NewMetricsService(storage))
+	subnets, err := middleware.ParseSubnets([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	srv.SetTrustedSubnets(subnets)
+
+	remoteWrite := remotewrite.Encode([]remotewrite.TimeSeries{{
+		Labels:  []remotewrite.Label{{Name: "__name__", Value: "up"}},
+		Samples: []remotewrite.Sample{{Value: 1, Timestamp: 1000}},
+	}})
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		encoding    string
+		body        string
+		wantStatus  int
+	}{
+		{name: "Influx v2", path: "/api/v2/write", body: "cpu load=1", wantStatus: http.StatusNoContent},
+		{name: "Influx v1", path: "/write", body: "cpu load=2", wantStatus: http.StatusNoContent},
+		{name: "OTLP", path: "/v1/metrics", contentType: "application/json", body: "{}", wantStatus: http.StatusOK},
+		{name: "Remote write", path: "/api/v1/write", contentType: "application/x-protobuf", encoding: "snappy", body: string(remoteWrite), wantStatus: http.StatusNoContent},
+		{name: "Agent updates still need a hash", path: "/update/gauge/temp/1", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newRequest := func(realIP string) *http.Request {
+				req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+				req.Header.Set(middleware.RealIPHeader, realIP)
+				if tt.contentType != "" {
+					req.Header.Set("Content-Type", tt.contentType)
+				}
+				if tt.encoding != "" {
+					req.Header.Set("Content-Encoding", tt.encoding)
+				}
+				return req
+			}
+
+			rec := httptest.NewRecorder()
+			srv.Srv.Handler.ServeHTTP(rec, newRequest("10.1.2.3"))
+			assert.Equal(t, tt.wantStatus, rec.Code, rec.Body.String())
+
+			rec = httptest.NewRecorder()
+			srv.Srv.Handler.ServeHTTP(rec, newRequest("192.168.1.1"))
+			assert.Equal(t, http.StatusForbidden, rec.Code, "trusted subnets still apply")
+		})
+	}
+}
+
+func TestHashedBodyLimit(t *testing.T) {
+	testLogger := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &testLogger
+
+	storage := db.NewStore("", 0)
+	defer storage.Close()
+	srv := NewServer(":8080", "hash-key", services.NewMetricsService(storage))
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write(make([]byte, middleware.MaxBodySize+1))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/updates/", &compressed)
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	srv.Srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "the decompressed body is capped before hashing")
+}
+
+type auditRecorder struct {
+	events []audit.Event
+}
+
+func (r *auditRecorder) Notify(ctx context.Context, event audit.Event) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestAudit(t *testing.T) {
+	testLogger := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &testLogger
+
+	storage := db.NewStore("", 0)
+	defer storage.Close()
+	metrics := services.NewMetricsService(storage)
+	auditor := audit.NewAuditor(nil)
+	recorder := &auditRecorder{}
+	auditor.Attach(recorder)
+	metrics.SetAuditor(auditor)
+	srv := NewServer(":8080", "", metrics)
+	testServer := httptest.NewServer(srv.Srv.Handler)
+	defer testServer.Close()
+
+	for _, req := range []struct {
+		path string
+		body string
+	}{
+		{path: "/update/gauge/temp/1"},
+		{path: "/update/gauge/temp/bad"},
+		{path: "/update/", body: `{"id":"json","type":"counter","delta":1}`},
+		{path: "/updates/", body: `[{"id":"a","type":"counter","delta":1},{"id":"b","type":"gauge","value":2}]`},
+	} {
+		httpReq, err := http.NewRequest(http.MethodPost, testServer.URL+req.path, strings.NewReader(req.body))
+		require.NoError(t, err)
+		httpReq.Header.Set(middleware.RealIPHeader, "10.0.0.1")
+		resp, err := http.DefaultClient.Do(httpReq)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, auditor.Run(done))
+
+	require.Len(t, recorder.events, 3, "failed updates are not audited")
+	assert.Equal(t, []string{"temp"}, recorder.events[0].Metrics)
+	assert.Equal(t, []string{"json"}, recorder.events[1].Metrics)
+	assert.Equal(t, []string{"a", "b"}, recorder.events[2].Metrics)
+	for _, event := range recorder.events {
+		assert.Equal(t, "10.0.0.1", event.IPAddress)
+		assert.NotZero(t, event.TS)
+	}
+}
@@ -2,23 +2,48 @@ package services
 
 import (
 	"context"
+	"sort"
 	"strconv"
+	"time"
 
+	"github.com/Heidric/metrics.git/internal/audit"
 	"github.com/Heidric/metrics.git/internal/customerrors"
 	"github.com/Heidric/metrics.git/internal/db"
 	"github.com/Heidric/metrics.git/internal/model"
 )
 
+const maxQueryPoints = 11000
+
 type MetricsService struct {
 	storage db.MetricsStorage
+	auditor *audit.Auditor
 }
 
 func NewMetricsService(storage db.MetricsStorage) *MetricsService {
 	return &MetricsService{storage: storage}
 }
 
-func (m *MetricsService) ListMetrics() map[string]string {
-	ctx := context.Background()
+// SetAuditor publishes an audit event for every successful update, whichever
+// transport it arrived on.
+func (m *MetricsService) SetAuditor(auditor *audit.Auditor) {
+	m.auditor = auditor
+}
+
+// publish records an update of the named metrics by the client IP the
+// transport stored in ctx. It is a no-op when the update failed.
+func (m *MetricsService) publish(ctx context.Context, err error, names ...string) error {
+	if err != nil || m.auditor == nil || len(names) == 0 {
+		return err
+	}
+	m.auditor.Publish(audit.Event{
+		TS:        time.Now().Unix(),
+		Metrics:   names,
+		IPAddress: audit.ClientIP(ctx),
+	})
+	return nil
+}
+
+func (m *MetricsService) ListMetrics(ctx context.Context) map[string]string {
 	result := make(map[string]string)
 	gauges, counters, err := m.storage.GetAll(ctx)
 	if err != nil {
@@ -31,11 +56,80 @@ func (m *MetricsService) ListMetrics() map[string]string {
 	for name, delta := range counters {
 		result[name] = strconv.FormatInt(delta, 10)
 	}
+
+	histograms, err := m.storage.GetHistograms(ctx)
+	if err != nil {
+		return result
+	}
+	for name, h := range histograms {
+		result[name] = h.String()
+	}
+
+	summaries, err := m.storage.GetSummaries(ctx)
+	if err != nil {
+		return result
+	}
+	for name, s := range summaries {
+		result[name] = s.String()
+	}
 	return result
 }
 
-func (m *MetricsService) GetMetric(metricType, metricName string) (string, error) {
-	ctx := context.Background()
+func (m *MetricsService) ListAllMetrics(ctx context.Context) ([]*model.Metrics, error) {
+	gauges, counters, err := m.storage.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Metrics, 0, len(gauges)+len(counters))
+	for key, value := range gauges {
+		v := value
+		result = appendSeries(result, key, &model.Metrics{MType: model.GaugeType, Value: &v})
+	}
+	for key, delta := range counters {
+		d := delta
+		result = appendSeries(result, key, &model.Metrics{MType: model.CounterType, Delta: &d})
+	}
+
+	histograms, err := m.storage.GetHistograms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for key, h := range histograms {
+		result = appendSeries(result, key, &model.Metrics{MType: model.HistogramType, Histogram: h})
+	}
+
+	summaries, err := m.storage.GetSummaries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for key, s := range summaries {
+		result = appendSeries(result, key, &model.Metrics{MType: model.SummaryType, Summary: s})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ID != result[j].ID {
+			return result[i].ID < result[j].ID
+		}
+		if result[i].MType != result[j].MType {
+			return result[i].MType < result[j].MType
+		}
+		return result[i].Labels.String() < result[j].Labels.String()
+	})
+	return result, nil
+}
+
+func appendSeries(result []*model.Metrics, key string, metric *model.Metrics) []*model.Metrics {
+	name, labels, err := model.ParseSeriesKey(key)
+	if err != nil {
+		name, labels = key, nil
+	}
+	metric.ID = name
+	metric.Labels = labels
+	return append(result, metric)
+}
+
+func (m *MetricsService) GetMetric(ctx context.Context, metricType, metricName string) (string, error) {
 	switch metricType {
 	case model.GaugeType:
 		val, err := m.storage.GetGauge(ctx, metricName)
@@ -49,86 +143,137 @@ func (m *MetricsService) GetMetric(metricType, metricName string) (string, error
 			return "", err
 		}
 		return strconv.FormatInt(val, 10), nil
+	case model.HistogramType:
+		val, err := m.storage.GetHistogram(ctx, metricName)
+		if err != nil {
+			return "", err
+		}
+		return val.String(), nil
+	case model.SummaryType:
+		val, err := m.storage.GetSummary(ctx, metricName)
+		if err != nil {
+			return "", err
+		}
+		return val.String(), nil
 	default:
 		return "", customerrors.ErrInvalidType
 	}
 }
 
-func (m *MetricsService) UpdateGauge(name, value string) error {
-	ctx := context.Background()
+func (m *MetricsService) UpdateGauge(ctx context.Context, name, value string) error {
+	if err := model.ValidateID(name); err != nil {
+		return err
+	}
 	val, err := strconv.ParseFloat(value, 64)
 	if err != nil {
 		return customerrors.ErrInvalidValue
 	}
-	return m.storage.SetGauge(ctx, name, val)
+	return m.publish(ctx, m.storage.SetGauge(ctx, name, val), name)
 }
 
-func (m *MetricsService) UpdateCounter(name, value string) error {
-	ctx := context.Background()
+func (m *MetricsService) UpdateCounter(ctx context.Context, name, value string) error {
+	if err := model.ValidateID(name); err != nil {
+		return err
+	}
 	delta, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
 		return customerrors.ErrInvalidValue
 	}
-	return m.storage.SetCounter(ctx, name, delta)
+	return m.publish(ctx, m.storage.SetCounter(ctx, name, delta), name)
 }
 
-func (m *MetricsService) UpdateMetricJSON(metric *model.Metrics) error {
-	ctx := context.Background()
+func (m *MetricsService) UpdateMetricJSON(ctx context.Context, metric *model.Metrics) error {
 	if metric == nil {
 		return customerrors.ErrInvalidValue
 	}
+	if err := model.ValidateID(metric.ID); err != nil {
+		return err
+	}
+	if err := metric.Labels.Validate(); err != nil {
+		return err
+	}
 
 	switch metric.MType {
 	case model.GaugeType:
 		if metric.Value == nil {
 			return customerrors.ErrInvalidValue
 		}
-		return m.storage.SetGauge(ctx, metric.ID, *metric.Value)
+		return m.publish(ctx, m.storage.SetGauge(ctx, metric.Key(), *metric.Value), metric.ID)
 	case model.CounterType:
 		if metric.Delta == nil {
 			return customerrors.ErrInvalidValue
 		}
-		return m.storage.SetCounter(ctx, metric.ID, *metric.Delta)
+		return m.publish(ctx, m.storage.SetCounter(ctx, metric.Key(), *metric.Delta), metric.ID)
+	case model.HistogramType:
+		if err := metric.Histogram.Validate(); err != nil {
+			return err
+		}
+		return m.publish(ctx, m.storage.SetHistogram(ctx, metric.Key(), metric.Histogram), metric.ID)
+	case model.SummaryType:
+		if err := metric.Summary.Validate(); err != nil {
+			return err
+		}
+		return m.publish(ctx, m.storage.SetSummary(ctx, metric.Key(), metric.Summary), metric.ID)
 	default:
 		return customerrors.ErrInvalidType
 	}
 }
 
-func (m *MetricsService) GetMetricJSON(metric *model.Metrics) error {
-	ctx := context.Background()
+func (m *MetricsService) GetMetricJSON(ctx context.Context, metric *model.Metrics) error {
 	if metric == nil {
 		return customerrors.ErrInvalidValue
 	}
+	if err := metric.Labels.Validate(); err != nil {
+		return err
+	}
 
 	switch metric.MType {
 	case model.GaugeType:
-		value, err := m.storage.GetGauge(ctx, metric.ID)
+		value, err := m.storage.GetGauge(ctx, metric.Key())
 		if err != nil {
 			return err
 		}
 		metric.Value = &value
-		metric.Delta = nil
+		metric.Delta, metric.Histogram, metric.Summary = nil, nil, nil
 		return nil
 	case model.CounterType:
-		delta, err := m.storage.GetCounter(ctx, metric.ID)
+		delta, err := m.storage.GetCounter(ctx, metric.Key())
 		if err != nil {
 			return err
 		}
 		metric.Delta = &delta
-		metric.Value = nil
+		metric.Value, metric.Histogram, metric.Summary = nil, nil, nil
+		return nil
+	case model.HistogramType:
+		h, err := m.storage.GetHistogram(ctx, metric.Key())
+		if err != nil {
+			return err
+		}
+		metric.Histogram = h
+		metric.Value, metric.Delta, metric.Summary = nil, nil, nil
+		return nil
+	case model.SummaryType:
+		sum, err := m.storage.GetSummary(ctx, metric.Key())
+		if err != nil {
+			return err
+		}
+		metric.Summary = sum
+		metric.Value, metric.Delta, metric.Histogram = nil, nil, nil
 		return nil
 	default:
 		return customerrors.ErrInvalidType
 	}
 }
 
-func (m *MetricsService) UpdateMetricsBatch(metrics []*model.Metrics) error {
-	ctx := context.Background()
+func (m *MetricsService) UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error {
 	var valid []*model.Metrics
 	for _, metric := range metrics {
 		if metric == nil || metric.ID == "" || metric.MType == "" {
 			continue
 		}
+		if model.ValidateID(metric.ID) != nil || metric.Labels.Validate() != nil {
+			continue
+		}
 		switch metric.MType {
 		case model.GaugeType:
 			if metric.Value == nil {
@@ -138,6 +283,14 @@ func (m *MetricsService) UpdateMetricsBatch(metrics []*model.Metrics) error {
 			if metric.Delta == nil {
 				continue
 			}
+		case model.HistogramType:
+			if metric.Histogram.Validate() != nil {
+				continue
+			}
+		case model.SummaryType:
+			if metric.Summary.Validate() != nil {
+				continue
+			}
 		default:
 			continue
 		}
@@ -146,7 +299,25 @@ func (m *MetricsService) UpdateMetricsBatch(metrics []*model.Metrics) error {
 	if len(valid) == 0 {
 		return nil
 	}
-	return m.storage.UpdateMetricsBatch(ctx, valid)
+
+	names := make([]string, 0, len(valid))
+	for _, metric := range valid {
+		names = append(names, metric.ID)
+	}
+	return m.publish(ctx, m.storage.UpdateMetricsBatch(ctx, valid), names...)
+}
+
+func (m *MetricsService) QueryRange(ctx context.Context, name, mtype string, from, to time.Time, step time.Duration) ([]*model.Series, error) {
+	if name == "" || to.Before(from) || step < 0 {
+		return nil, customerrors.ErrInvalidValue
+	}
+	if mtype != model.GaugeType && mtype != model.CounterType {
+		return nil, customerrors.ErrInvalidType
+	}
+	if step > 0 && to.Sub(from)/step > maxQueryPoints {
+		return nil, customerrors.ErrInvalidValue
+	}
+	return m.storage.QueryRange(ctx, name, mtype, from, to, step)
 }
 
 func (m *MetricsService) Ping(ctx context.Context) error {
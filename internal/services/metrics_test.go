@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/Heidric/metrics.git/internal/customerrors"
 	"github.com/Heidric/metrics.git/internal/model"
@@ -13,6 +14,8 @@ import (
 type mockStorage struct {
 	gauges               map[string]float64
 	counters             map[string]int64
+	histograms           map[string]*model.Histogram
+	summaries            map[string]*model.Summary
 	updateMetricsBatchFn func(metrics []*model.Metrics) error
 }
 
@@ -51,6 +54,46 @@ func (m *mockStorage) GetAll(ctx context.Context) (map[string]float64, map[strin
 	return m.gauges, m.counters, nil
 }
 
+func (m *mockStorage) SetHistogram(ctx context.Context, name string, value *model.Histogram) error {
+	if m.histograms == nil {
+		m.histograms = make(map[string]*model.Histogram)
+	}
+	m.histograms[name] = value
+	return nil
+}
+
+func (m *mockStorage) GetHistogram(ctx context.Context, name string) (*model.Histogram, error) {
+	val, ok := m.histograms[name]
+	if !ok {
+		return nil, customerrors.ErrKeyNotFound
+	}
+	return val, nil
+}
+
+func (m *mockStorage) GetHistograms(ctx context.Context) (map[string]*model.Histogram, error) {
+	return m.histograms, nil
+}
+
+func (m *mockStorage) SetSummary(ctx context.Context, name string, value *model.Summary) error {
+	if m.summaries == nil {
+		m.summaries = make(map[string]*model.Summary)
+	}
+	m.summaries[name] = value
+	return nil
+}
+
+func (m *mockStorage) GetSummary(ctx context.Context, name string) (*model.Summary, error) {
+	val, ok := m.summaries[name]
+	if !ok {
+		return nil, customerrors.ErrKeyNotFound
+	}
+	return val, nil
+}
+
+func (m *mockStorage) GetSummaries(ctx context.Context) (map[string]*model.Summary, error) {
+	return m.summaries, nil
+}
+
 func (m *mockStorage) UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error {
 	if m.updateMetricsBatchFn != nil {
 		return m.updateMetricsBatchFn(metrics)
@@ -58,6 +101,10 @@ func (m *mockStorage) UpdateMetricsBatch(ctx context.Context, metrics []*model.M
 	return nil
 }
 
+func (m *mockStorage) QueryRange(ctx context.Context, name, mtype string, from, to time.Time, step time.Duration) ([]*model.Series, error) {
+	return []*model.Series{{ID: name, MType: mtype}}, nil
+}
+
 func (m *mockStorage) Ping(ctx context.Context) error {
 	return nil
 }
@@ -67,6 +114,8 @@ func (m *mockStorage) Close() error {
 }
 
 func TestMetricsService(t *testing.T) {
+	ctx := context.Background()
+
 	t.Run("UpdateGauge", func(t *testing.T) {
 		storage := &mockStorage{
 			gauges:   make(map[string]float64),
@@ -74,7 +123,7 @@ func TestMetricsService(t *testing.T) {
 		}
 		service := NewMetricsService(storage)
 
-		err := service.UpdateGauge("temp", "42.5")
+		err := service.UpdateGauge(ctx, "temp", "42.5")
 		require.NoError(t, err)
 
 		assert.Equal(t, 42.5, storage.gauges["temp"])
@@ -87,10 +136,10 @@ func TestMetricsService(t *testing.T) {
 		}
 		service := NewMetricsService(storage)
 
-		err := service.UpdateCounter("hits", "10")
+		err := service.UpdateCounter(ctx, "hits", "10")
 		require.NoError(t, err)
 
-		err = service.UpdateCounter("hits", "5")
+		err = service.UpdateCounter(ctx, "hits", "5")
 		require.NoError(t, err)
 
 		assert.Equal(t, int64(15), storage.counters["hits"])
@@ -103,7 +152,7 @@ func TestMetricsService(t *testing.T) {
 		}
 		service := NewMetricsService(storage)
 
-		val, err := service.GetMetric(model.GaugeType, "temp")
+		val, err := service.GetMetric(ctx, model.GaugeType, "temp")
 		require.NoError(t, err)
 		assert.Equal(t, "42.5", val)
 	})
@@ -115,7 +164,7 @@ func TestMetricsService(t *testing.T) {
 		}
 		service := NewMetricsService(storage)
 
-		val, err := service.GetMetric(model.CounterType, "hits")
+		val, err := service.GetMetric(ctx, model.CounterType, "hits")
 		require.NoError(t, err)
 		assert.Equal(t, "15", val)
 	})
@@ -127,12 +176,131 @@ func TestMetricsService(t *testing.T) {
 		}
 		service := NewMetricsService(storage)
 
-		metrics := service.ListMetrics()
+		metrics := service.ListMetrics(ctx)
 		assert.Equal(t, 2, len(metrics))
 		assert.Equal(t, "1.1", metrics["gauge1"])
 		assert.Equal(t, "10", metrics["counter1"])
 	})
 
+	t.Run("ListAllMetrics", func(t *testing.T) {
+		storage := &mockStorage{
+			gauges:   map[string]float64{"b": 1.1, "a": 2.2},
+			counters: map[string]int64{"a": 10},
+		}
+		service := NewMetricsService(storage)
+
+		metrics, err := service.ListAllMetrics(ctx)
+		require.NoError(t, err)
+		require.Len(t, metrics, 3)
+		assert.Equal(t, "a", metrics[0].ID)
+		assert.Equal(t, model.CounterType, metrics[0].MType)
+		assert.Equal(t, int64(10), *metrics[0].Delta)
+		assert.Equal(t, "a", metrics[1].ID)
+		assert.Equal(t, model.GaugeType, metrics[1].MType)
+		assert.Equal(t, "b", metrics[2].ID)
+		assert.Equal(t, 1.1, *metrics[2].Value)
+	})
+
+	t.Run("UpdateMetricJSON with labels", func(t *testing.T) {
+		storage := &mockStorage{
+			gauges:   make(map[string]float64),
+			counters: make(map[string]int64),
+		}
+		service := NewMetricsService(storage)
+
+		v := 1.5
+		err := service.UpdateMetricJSON(ctx, &model.Metrics{
+			ID: "Alloc", MType: model.GaugeType, Value: &v, Labels: model.Labels{"host": "a"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1.5, storage.gauges[`Alloc{host="a"}`])
+
+		metric := &model.Metrics{ID: "Alloc", MType: model.GaugeType, Labels: model.Labels{"host": "a"}}
+		require.NoError(t, service.GetMetricJSON(ctx, metric))
+		assert.Equal(t, 1.5, *metric.Value)
+
+		err = service.UpdateMetricJSON(ctx, &model.Metrics{
+			ID: "Alloc", MType: model.GaugeType, Value: &v, Labels: model.Labels{"bad-name": "a"},
+		})
+		assert.ErrorIs(t, err, customerrors.ErrInvalidLabels)
+
+		err = service.UpdateMetricJSON(ctx, &model.Metrics{ID: `Alloc{host="a"}`, MType: model.GaugeType, Value: &v})
+		assert.ErrorIs(t, err, customerrors.ErrInvalidValue, "an ID cannot spell out a labelled series")
+		assert.ErrorIs(t, service.UpdateGauge(ctx, `Alloc{host="a"}`, "2"), customerrors.ErrInvalidValue)
+
+		all, err := service.ListAllMetrics(ctx)
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+		assert.Equal(t, "Alloc", all[0].ID)
+		assert.Equal(t, model.Labels{"host": "a"}, all[0].Labels)
+	})
+
+	t.Run("Histogram and summary", func(t *testing.T) {
+		storage := &mockStorage{}
+		service := NewMetricsService(storage)
+
+		h := model.NewHistogram([]float64{0.1, 1})
+		h.Observe(0.5)
+		require.NoError(t, service.UpdateMetricJSON(ctx, &model.Metrics{ID: "latency", MType: model.HistogramType, Histogram: h}))
+
+		err := service.UpdateMetricJSON(ctx, &model.Metrics{ID: "latency", MType: model.HistogramType})
+		assert.ErrorIs(t, err, customerrors.ErrInvalidValue)
+
+		sum := &model.Summary{Quantiles: []model.Quantile{{Quantile: 0.5, Value: 0.3}}, Sum: 0.3, Count: 1}
+		require.NoError(t, service.UpdateMetricJSON(ctx, &model.Metrics{ID: "latency_q", MType: model.SummaryType, Summary: sum}))
+
+		metric := &model.Metrics{ID: "latency", MType: model.HistogramType}
+		require.NoError(t, service.GetMetricJSON(ctx, metric))
+		assert.Equal(t, uint64(1), metric.Histogram.Count)
+
+		val, err := service.GetMetric(ctx, model.SummaryType, "latency_q")
+		require.NoError(t, err)
+		assert.Equal(t, "count=1 sum=0.3 quantiles=[0.5:0.3]", val)
+
+		list := service.ListMetrics(ctx)
+		assert.Equal(t, "count=1 sum=0.5 buckets=[0.1:0 1:1 +Inf:1]", list["latency"])
+
+		all, err := service.ListAllMetrics(ctx)
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+		assert.Equal(t, model.HistogramType, all[0].MType)
+		assert.Equal(t, model.SummaryType, all[1].MType)
+
+		var batch []*model.Metrics
+		storage.updateMetricsBatchFn = func(metrics []*model.Metrics) error {
+			batch = metrics
+			return nil
+		}
+		require.NoError(t, service.UpdateMetricsBatch(ctx, []*model.Metrics{
+			{ID: "ok", MType: model.HistogramType, Histogram: h},
+			{ID: "bad", MType: model.HistogramType, Histogram: &model.Histogram{Buckets: []model.Bucket{{UpperBound: 1, Count: 5}}}},
+			{ID: "none", MType: model.SummaryType},
+		}))
+		require.Len(t, batch, 1)
+		assert.Equal(t, "ok", batch[0].ID)
+	})
+
+	t.Run("QueryRange validation", func(t *testing.T) {
+		service := NewMetricsService(&mockStorage{})
+		now := time.Now()
+
+		series, err := service.QueryRange(ctx, "Alloc", model.GaugeType, now.Add(-time.Hour), now, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, series, 1)
+
+		_, err = service.QueryRange(ctx, "Alloc", "text", now.Add(-time.Hour), now, time.Minute)
+		assert.ErrorIs(t, err, customerrors.ErrInvalidType)
+
+		_, err = service.QueryRange(ctx, "Alloc", model.GaugeType, now, now.Add(-time.Hour), 0)
+		assert.ErrorIs(t, err, customerrors.ErrInvalidValue)
+
+		_, err = service.QueryRange(ctx, "Alloc", model.GaugeType, now.Add(-24*time.Hour), now, time.Second)
+		assert.ErrorIs(t, err, customerrors.ErrInvalidValue)
+
+		_, err = service.QueryRange(ctx, "", model.GaugeType, now.Add(-time.Hour), now, 0)
+		assert.ErrorIs(t, err, customerrors.ErrInvalidValue)
+	})
+
 	t.Run("Ping", func(t *testing.T) {
 		storage := &mockStorage{}
 		service := NewMetricsService(storage)
@@ -0,0 +1,179 @@
+package statsd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+)
+
+const maxPacketSize = 65535
+
+type Config struct {
+	Address       string
+	FlushInterval time.Duration
+	TimerMode     string
+}
+
+// Listener accepts StatsD lines over UDP and TCP on the same address and
+// flushes the aggregated metrics to the sink on an interval.
+type Listener struct {
+	cfg    Config
+	sink   Sink
+	logger *zerolog.Logger
+	agg    *aggregator
+
+	udp net.PacketConn
+	tcp net.Listener
+	// readers tracks everything that feeds the aggregator, so the final
+	// flush runs only after the last line is in.
+	readers sync.WaitGroup
+}
+
+func NewListener(cfg Config, sink Sink, logger *zerolog.Logger) *Listener {
+	if logger == nil {
+		nop := zerolog.Nop()
+		logger = &nop
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 10 * time.Second
+	}
+	if cfg.TimerMode != TimerModeGauge {
+		cfg.TimerMode = TimerModeHistogram
+	}
+
+	return &Listener{
+		cfg:    cfg,
+		sink:   sink,
+		logger: logger,
+		agg:    newAggregator(cfg.TimerMode),
+	}
+}
+
+func (l *Listener) Run(ctx context.Context, runner *errgroup.Group) error {
+	tcp, err := net.Listen("tcp", l.cfg.Address)
+	if err != nil {
+		return err
+	}
+	udp, err := net.ListenPacket("udp", tcp.Addr().String())
+	if err != nil {
+		tcp.Close()
+		return err
+	}
+	l.tcp, l.udp = tcp, udp
+
+	l.logger.Info().Str("address", tcp.Addr().String()).Msg("StatsD listener started")
+
+	runner.Go(func() error {
+		<-ctx.Done()
+		tcp.Close()
+		udp.Close()
+		return nil
+	})
+	l.readers.Add(2)
+	runner.Go(func() error {
+		defer l.readers.Done()
+		return l.serveUDP(ctx)
+	})
+	runner.Go(func() error {
+		defer l.readers.Done()
+		return l.serveTCP(ctx)
+	})
+	runner.Go(func() error {
+		return l.flushLoop(ctx)
+	})
+	return nil
+}
+
+func (l *Listener) serveUDP(ctx context.Context) error {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := l.udp.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			l.logger.Error().Err(err).Msg("Failed to read StatsD packet")
+			continue
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			l.handle(line)
+		}
+	}
+}
+
+func (l *Listener) serveTCP(ctx context.Context) error {
+	for {
+		conn, err := l.tcp.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			l.logger.Error().Err(err).Msg("Failed to accept StatsD connection")
+			continue
+		}
+		l.readers.Add(1)
+		go func() {
+			defer l.readers.Done()
+			l.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (l *Listener) serveConn(ctx context.Context, conn net.Conn) {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxPacketSize)
+	for scanner.Scan() {
+		l.handle(scanner.Text())
+	}
+}
+
+func (l *Listener) handle(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	s, err := parseLine(line)
+	if err != nil {
+		l.logger.Debug().Str("line", line).Msg("Invalid StatsD line dropped")
+		return
+	}
+	l.agg.add(s)
+}
+
+func (l *Listener) flushLoop(ctx context.Context) error {
+	ticker := time.NewTicker(l.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			l.readers.Wait()
+			l.flush(context.WithoutCancel(ctx))
+			return nil
+		case <-ticker.C:
+			l.flush(ctx)
+		}
+	}
+}
+
+func (l *Listener) flush(ctx context.Context) {
+	metrics, drained := l.agg.flush()
+	if len(metrics) == 0 {
+		return
+	}
+	if err := l.sink.UpdateMetricsBatch(ctx, metrics); err != nil {
+		l.logger.Error().Err(err).Int("metrics", len(metrics)).Msg("Failed to flush StatsD metrics")
+		l.agg.restore(drained)
+	}
+}
@@ -0,0 +1,281 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Heidric/metrics.git/internal/model"
+)
+
+const (
+	TimerModeHistogram = "histogram"
+	TimerModeGauge     = "gauge"
+)
+
+// minSampleRate bounds the weight of a single sampled line, so a tiny rate
+// cannot overflow counters or histogram counts.
+const minSampleRate = 1e-9
+
+var errInvalidLine = errors.New("invalid statsd line")
+
+type Sink interface {
+	UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error
+}
+
+type sample struct {
+	name   string
+	labels model.Labels
+	kind   string
+	raw    string
+	value  float64
+	rate   float64
+}
+
+// parseLine parses name:value|type[|@rate][|#tag:value,...]. DogStatsD tags
+// become labels; a tag without a value gets an empty one.
+func parseLine(line string) (sample, error) {
+	var s sample
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return s, errInvalidLine
+	}
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 || fields[0] == "" {
+		return s, errInvalidLine
+	}
+
+	s.name = name
+	s.raw = fields[0]
+	s.kind = fields[1]
+	s.rate = 1
+	switch s.kind {
+	case "c", "g", "ms", "h":
+		v, err := strconv.ParseFloat(s.raw, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return s, errInvalidLine
+		}
+		s.value = v
+	case "s":
+	default:
+		return s, errInvalidLine
+	}
+
+	for _, field := range fields[2:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return s, errInvalidLine
+			}
+			s.rate = math.Max(rate, minSampleRate)
+		case strings.HasPrefix(field, "#"):
+			s.labels = make(model.Labels)
+			for _, tag := range strings.Split(field[1:], ",") {
+				if tag == "" {
+					continue
+				}
+				k, v, _ := strings.Cut(tag, ":")
+				s.labels[k] = v
+			}
+			if err := s.labels.Validate(); err != nil {
+				return s, errInvalidLine
+			}
+		default:
+			return s, errInvalidLine
+		}
+	}
+	return s, nil
+}
+
+type entry struct {
+	id     string
+	labels model.Labels
+	value  float64
+	count  float64
+	hist   *model.Histogram
+	set    map[string]struct{}
+	dirty  bool
+}
+
+// aggregator accumulates samples between flushes. Counters and sets reset on
+// every flush, gauges keep their last value so relative updates work, and
+// timer histograms stay cumulative because storage replaces them wholesale.
+type aggregator struct {
+	timerMode string
+
+	mu       sync.Mutex
+	counters map[string]*entry
+	gauges   map[string]*entry
+	timers   map[string]*entry
+	sets     map[string]*entry
+}
+
+func newAggregator(timerMode string) *aggregator {
+	return &aggregator{
+		timerMode: timerMode,
+		counters:  make(map[string]*entry),
+		gauges:    make(map[string]*entry),
+		timers:    make(map[string]*entry),
+		sets:      make(map[string]*entry),
+	}
+}
+
+func lookup(entries map[string]*entry, s sample) *entry {
+	key := model.SeriesKey(s.name, s.labels)
+	e, ok := entries[key]
+	if !ok {
+		e = &entry{id: s.name, labels: s.labels}
+		entries[key] = e
+	}
+	e.dirty = true
+	return e
+}
+
+func (a *aggregator) add(s sample) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch s.kind {
+	case "c":
+		lookup(a.counters, s).value += s.value / s.rate
+	case "g":
+		e := lookup(a.gauges, s)
+		if s.raw[0] == '+' || s.raw[0] == '-' {
+			e.value += s.value
+		} else {
+			e.value = s.value
+		}
+	case "ms", "h":
+		// Timers arrive in milliseconds and are recorded in seconds to match
+		// model.DefaultBuckets.
+		e := lookup(a.timers, s)
+		seconds := s.value / 1000
+		weight := math.Max(1, math.Round(1/s.rate))
+		if a.timerMode == TimerModeGauge {
+			e.value += seconds * weight
+			e.count += weight
+			return
+		}
+		if e.hist == nil {
+			e.hist = model.NewHistogram(nil)
+		}
+		e.hist.ObserveN(seconds, uint64(weight))
+	case "s":
+		e := lookup(a.sets, s)
+		if e.set == nil {
+			e.set = make(map[string]struct{})
+		}
+		e.set[s.raw] = struct{}{}
+	}
+}
+
+// drained holds the entries a flush removed from the aggregator, so they can
+// be merged back if the flushed metrics could not be stored.
+type drained struct {
+	counters map[string]*entry
+	timers   map[string]*entry
+	sets     map[string]*entry
+	clean    []*entry
+}
+
+// flush returns the metrics updated since the previous flush.
+func (a *aggregator) flush() ([]*model.Metrics, *drained) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	d := &drained{
+		counters: a.counters,
+		timers:   make(map[string]*entry),
+		sets:     a.sets,
+	}
+	a.counters = make(map[string]*entry)
+	a.sets = make(map[string]*entry)
+
+	var result []*model.Metrics
+	for key, e := range d.counters {
+		if !e.dirty {
+			// Only a carried remainder, nothing to report yet.
+			a.counters[key] = e
+			delete(d.counters, key)
+			continue
+		}
+		// Sampled counters are fractional. Storage counts in integers, so the
+		// rounding error is carried into the next flush instead of being lost.
+		delta := int64(math.Round(e.value))
+		if rest := e.value - float64(delta); rest != 0 {
+			a.counters[key] = &entry{id: e.id, labels: e.labels, value: rest}
+			e.value = float64(delta)
+		}
+		result = append(result, &model.Metrics{ID: e.id, MType: model.CounterType, Delta: &delta, Labels: e.labels})
+	}
+	for _, e := range a.gauges {
+		if !e.dirty {
+			continue
+		}
+		value := e.value
+		result = append(result, &model.Metrics{ID: e.id, MType: model.GaugeType, Value: &value, Labels: e.labels})
+		e.dirty = false
+		d.clean = append(d.clean, e)
+	}
+	for key, e := range a.timers {
+		if !e.dirty {
+			continue
+		}
+		if a.timerMode == TimerModeGauge {
+			mean := e.value / e.count
+			result = append(result, &model.Metrics{ID: e.id, MType: model.GaugeType, Value: &mean, Labels: e.labels})
+			delete(a.timers, key)
+			d.timers[key] = e
+			continue
+		}
+		result = append(result, &model.Metrics{ID: e.id, MType: model.HistogramType, Histogram: e.hist.Clone(), Labels: e.labels})
+		e.dirty = false
+		d.clean = append(d.clean, e)
+	}
+	for _, e := range d.sets {
+		unique := float64(len(e.set))
+		result = append(result, &model.Metrics{ID: e.id, MType: model.GaugeType, Value: &unique, Labels: e.labels})
+	}
+	return result, d
+}
+
+// restore merges the entries of a failed flush with whatever arrived since,
+// so the next flush reports both.
+func (a *aggregator) restore(d *drained) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, old := range d.counters {
+		if e, ok := a.counters[key]; ok {
+			e.value += old.value
+			e.dirty = true
+		} else {
+			a.counters[key] = old
+		}
+	}
+	for key, old := range d.timers {
+		if e, ok := a.timers[key]; ok {
+			e.value += old.value
+			e.count += old.count
+		} else {
+			a.timers[key] = old
+		}
+	}
+	for key, old := range d.sets {
+		e, ok := a.sets[key]
+		if !ok {
+			a.sets[key] = old
+			continue
+		}
+		for member := range old.set {
+			e.set[member] = struct{}{}
+		}
+	}
+	for _, e := range d.clean {
+		e.dirty = true
+	}
+}
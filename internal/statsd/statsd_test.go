@@ -0,0 +1,273 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/audit"
+	"github.com/Heidric/metrics.git/internal/db"
+	"github.com/Heidric/metrics.git/internal/model"
+	"github.com/Heidric/metrics.git/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	batches [][]*model.Metrics
+}
+
+func (r *recordingSink) UpdateMetricsBatch(ctx context.Context, metrics []*model.Metrics) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, metrics)
+	return nil
+}
+
+func (r *recordingSink) metrics() []*model.Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []*model.Metrics
+	for _, batch := range r.batches {
+		result = append(result, batch...)
+	}
+	return result
+}
+
+func byKey(metrics []*model.Metrics) map[string]*model.Metrics {
+	result := make(map[string]*model.Metrics, len(metrics))
+	for _, m := range metrics {
+		result[m.MType+":"+m.Key()] = m
+	}
+	return result
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    sample
+		wantErr bool
+	}{
+		{line: "hits:1|c", want: sample{name: "hits", kind: "c", raw: "1", value: 1, rate: 1}},
+		{line: "hits:2|c|@0.5", want: sample{name: "hits", kind: "c", raw: "2", value: 2, rate: 0.5}},
+		{line: "temp:-3.5|g", want: sample{name: "temp", kind: "g", raw: "-3.5", value: -3.5, rate: 1}},
+		{line: "users:alice|s", want: sample{name: "users", kind: "s", raw: "alice", rate: 1}},
+		{
+			line: "api.latency:12|ms|@0.1|#host:a,canary",
+			want: sample{name: "api.latency", kind: "ms", raw: "12", value: 12, rate: 0.1, labels: model.Labels{"host": "a", "canary": ""}},
+		},
+		{line: "hits", wantErr: true},
+		{line: ":1|c", wantErr: true},
+		{line: "hits:1", wantErr: true},
+		{line: "hits:x|c", wantErr: true},
+		{line: "hits:1|x", wantErr: true},
+		{line: "hits:1|c|@0", wantErr: true},
+		{line: "hits:1|c|@2", wantErr: true},
+		{line: "hits:1|c|#bad-tag:a", wantErr: true},
+		{line: "hits:NaN|g", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := parseLine(tt.line)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func flushMetrics(a *aggregator) []*model.Metrics {
+	metrics, _ := a.flush()
+	return metrics
+}
+
+func TestAggregator(t *testing.T) {
+	add := func(a *aggregator, lines ...string) {
+		for _, line := range lines {
+			s, err := parseLine(line)
+			require.NoError(t, err)
+			a.add(s)
+		}
+	}
+
+	t.Run("Counters, gauges and sets", func(t *testing.T) {
+		a := newAggregator(TimerModeHistogram)
+		add(a, "hits:1|c", "hits:1|c|@0.5", "hits:1|c|#host:a",
+			"temp:10|g", "temp:+5|g", "temp:-2|g",
+			"users:alice|s", "users:bob|s", "users:alice|s")
+
+		got := byKey(flushMetrics(a))
+		require.Len(t, got, 4)
+		assert.Equal(t, int64(3), *got["counter:hits"].Delta)
+		assert.Equal(t, int64(1), *got[`counter:hits{host="a"}`].Delta)
+		assert.Equal(t, 13.0, *got["gauge:temp"].Value)
+		assert.Equal(t, 2.0, *got["gauge:users"].Value)
+
+		assert.Empty(t, flushMetrics(a), "nothing changed since the last flush")
+
+		add(a, "temp:+1|g", "hits:4|c")
+		got = byKey(flushMetrics(a))
+		assert.Equal(t, 14.0, *got["gauge:temp"].Value, "relative gauge updates survive flushes")
+		assert.Equal(t, int64(4), *got["counter:hits"].Delta, "counters reset on flush")
+	})
+
+	t.Run("Sampled counter remainders carry over", func(t *testing.T) {
+		a := newAggregator(TimerModeHistogram)
+		add(a, "hits:1|c|@0.4")
+		assert.Equal(t, int64(3), *byKey(flushMetrics(a))["counter:hits"].Delta)
+
+		add(a, "hits:1|c|@0.4")
+		assert.Equal(t, int64(2), *byKey(flushMetrics(a))["counter:hits"].Delta, "2.5 sampled twice adds up to 5")
+
+		add(a, "hits:1|c|@0.3")
+		_, d := a.flush()
+		a.restore(d)
+		assert.Equal(t, int64(3), *byKey(flushMetrics(a))["counter:hits"].Delta)
+		add(a, "hits:1|c|@0.3")
+		assert.Equal(t, int64(4), *byKey(flushMetrics(a))["counter:hits"].Delta, "a restored flush keeps the remainder once")
+		assert.Empty(t, flushMetrics(a), "a remainder alone is not reported")
+	})
+
+	t.Run("Timers as histograms", func(t *testing.T) {
+		a := newAggregator(TimerModeHistogram)
+		add(a, "latency:20|ms", "latency:300|ms|@0.5")
+
+		got := byKey(flushMetrics(a))
+		h := got["histogram:latency"].Histogram
+		require.NotNil(t, h)
+		assert.Equal(t, uint64(3), h.Count)
+		assert.InDelta(t, 0.62, h.Sum, 1e-9)
+		assert.NoError(t, h.Validate())
+
+		add(a, "latency:1|ms")
+		h = byKey(flushMetrics(a))["histogram:latency"].Histogram
+		assert.Equal(t, uint64(4), h.Count, "histograms are cumulative across flushes")
+	})
+
+	t.Run("Tiny sample rates", func(t *testing.T) {
+		a := newAggregator(TimerModeHistogram)
+		add(a, "latency:1|ms|@1e-300")
+
+		h := byKey(flushMetrics(a))["histogram:latency"].Histogram
+		assert.Equal(t, uint64(1/minSampleRate), h.Count, "the rate is floored at minSampleRate")
+	})
+
+	t.Run("Restore after a failed flush", func(t *testing.T) {
+		a := newAggregator(TimerModeGauge)
+		add(a, "hits:2|c", "users:alice|s", "temp:1|g", "latency:100|ms")
+		_, d := a.flush()
+
+		add(a, "hits:3|c", "users:bob|s", "latency:300|ms")
+		a.restore(d)
+
+		got := byKey(flushMetrics(a))
+		require.Len(t, got, 4)
+		assert.Equal(t, int64(5), *got["counter:hits"].Delta)
+		assert.Equal(t, 2.0, *got["gauge:users"].Value)
+		assert.Equal(t, 1.0, *got["gauge:temp"].Value, "unsent gauges are flushed again")
+		assert.InDelta(t, 0.2, *got["gauge:latency"].Value, 1e-9)
+	})
+
+	t.Run("Timers as gauges", func(t *testing.T) {
+		a := newAggregator(TimerModeGauge)
+		add(a, "latency:100|ms", "latency:300|ms")
+
+		got := byKey(flushMetrics(a))
+		assert.InDelta(t, 0.2, *got["gauge:latency"].Value, 1e-9)
+		assert.Empty(t, flushMetrics(a))
+	})
+}
+
+func TestListener(t *testing.T) {
+	sink := &recordingSink{}
+	l := NewListener(Config{Address: "127.0.0.1:0", FlushInterval: 20 * time.Millisecond}, sink, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runner, ctx := errgroup.WithContext(ctx)
+	require.NoError(t, l.Run(ctx, runner))
+
+	udp, err := net.Dial("udp", l.udp.LocalAddr().String())
+	require.NoError(t, err)
+	defer udp.Close()
+	_, err = udp.Write([]byte("udp.hits:2|c\nudp.temp:1.5|g\ngarbage"))
+	require.NoError(t, err)
+
+	tcp, err := net.Dial("tcp", l.tcp.Addr().String())
+	require.NoError(t, err)
+	_, err = tcp.Write([]byte("tcp.hits:3|c\n"))
+	require.NoError(t, err)
+	tcp.Close()
+
+	assert.Eventually(t, func() bool {
+		got := byKey(sink.metrics())
+		return got["counter:udp.hits"] != nil && got["gauge:udp.temp"] != nil && got["counter:tcp.hits"] != nil
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, runner.Wait())
+
+	var ids []string
+	for _, m := range sink.metrics() {
+		ids = append(ids, m.ID)
+	}
+	sort.Strings(ids)
+	assert.Equal(t, []string{"tcp.hits", "udp.hits", "udp.temp"}, ids)
+}
+
+type auditRecorder struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (r *auditRecorder) Notify(ctx context.Context, event audit.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestListenerAudit(t *testing.T) {
+	storage := db.NewStore("", 0)
+	defer storage.Close()
+	metrics := services.NewMetricsService(storage)
+	auditor := audit.NewAuditor(nil)
+	recorder := &auditRecorder{}
+	auditor.Attach(recorder)
+	metrics.SetAuditor(auditor)
+
+	l := NewListener(Config{Address: "127.0.0.1:0", FlushInterval: time.Hour}, metrics, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	runner, ctx := errgroup.WithContext(ctx)
+	require.NoError(t, l.Run(ctx, runner))
+
+	udp, err := net.Dial("udp", l.udp.LocalAddr().String())
+	require.NoError(t, err)
+	defer udp.Close()
+	_, err = udp.Write([]byte("hits:2|c"))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		l.agg.mu.Lock()
+		defer l.agg.mu.Unlock()
+		return len(l.agg.counters) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, runner.Wait())
+
+	done, stop := context.WithCancel(context.Background())
+	stop()
+	require.NoError(t, auditor.Run(done))
+
+	require.Len(t, recorder.events, 1, "the final flush is audited")
+	assert.Equal(t, []string{"hits"}, recorder.events[0].Metrics)
+}
@@ -0,0 +1,185 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Heidric/metrics.git/internal/logger"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	path string
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644))
+	return &testCA{cert: cert, key: key, path: path}
+}
+
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64, usage x509.ExtKeyUsage) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath = filepath.Join(dir, name+".pem")
+	keyPath = filepath.Join(dir, name+"-key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certPath, keyPath
+}
+
+func newTLSServer(t *testing.T, cfg *tls.Config) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "server", 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", 3, x509.ExtKeyUsageClientAuth)
+
+	serverReloader, err := NewCertReloader(serverCert, serverKey)
+	require.NoError(t, err)
+	serverCfg, err := ServerConfig(serverReloader, ca.path)
+	require.NoError(t, err)
+	srv := newTLSServer(t, serverCfg)
+
+	clientReloader, err := NewCertReloader(clientCert, clientKey)
+	require.NoError(t, err)
+
+	t.Run("Client with certificate", func(t *testing.T) {
+		cfg, err := ClientConfig(ca.path, clientReloader, "localhost")
+		require.NoError(t, err)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+
+		resp, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Client without certificate is rejected", func(t *testing.T) {
+		cfg, err := ClientConfig(ca.path, nil, "localhost")
+		require.NoError(t, err)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+
+		_, err = client.Get(srv.URL)
+		assert.Error(t, err)
+	})
+
+	t.Run("Unknown server CA is rejected", func(t *testing.T) {
+		other := newTestCA(t, t.TempDir())
+		cfg, err := ClientConfig(other.path, clientReloader, "localhost")
+		require.NoError(t, err)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+
+		_, err = client.Get(srv.URL)
+		assert.Error(t, err)
+	})
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	l := zerolog.New(nil).Level(zerolog.Disabled)
+	logger.Log = &l
+
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	certPath, keyPath := ca.issue(t, dir, "server", 2, x509.ExtKeyUsageServerAuth)
+
+	reloader, err := NewCertReloader(certPath, keyPath)
+	require.NoError(t, err)
+	before, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10*time.Millisecond)
+
+	renewedCert, renewedKey := ca.issue(t, t.TempDir(), "server", 4, x509.ExtKeyUsageServerAuth)
+	for src, dst := range map[string]string{renewedCert: certPath, renewedKey: keyPath} {
+		data, err := os.ReadFile(src)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(dst, data, 0o600))
+	}
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certPath, future, future))
+	require.NoError(t, os.Chtimes(keyPath, future, future))
+
+	require.Eventually(t, func() bool {
+		cert, _ := reloader.GetCertificate(nil)
+		return cert != before
+	}, time.Second, 10*time.Millisecond)
+
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), leaf.SerialNumber.Int64())
+
+	require.NoError(t, os.WriteFile(certPath, []byte("broken"), 0o644))
+	require.NoError(t, os.Chtimes(certPath, future.Add(time.Minute), future.Add(time.Minute)))
+	time.Sleep(50 * time.Millisecond)
+	kept, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Same(t, cert, kept, "a broken certificate must not replace the current one")
+}